@@ -0,0 +1,149 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Bowery/gopackages/requests"
+	"github.com/Bowery/gopackages/sys"
+	"github.com/gorilla/websocket"
+)
+
+var errUsage = errors.New("invalid usage")
+
+// API is a client for the running Bowery client's api.
+type API struct {
+	Addr   string
+	Socket string
+	Token  string
+	client *http.Client
+}
+
+// NewAPI creates an API for the address, or the unix socket if given.
+// The token is read from the file the client writes on start.
+func NewAPI(addr, socket string) (*API, error) {
+	token, err := ioutil.ReadFile(filepath.Join(os.Getenv(sys.HomeVar), ".bowery_token"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = errors.New("no api token found, is the Bowery client running?")
+		}
+
+		return nil, err
+	}
+
+	api := &API{Addr: addr, Socket: socket, Token: strings.TrimSpace(string(token))}
+	transport := &http.Transport{Dial: api.dial}
+	api.client = &http.Client{Transport: transport}
+
+	return api, nil
+}
+
+// Do sends a request with the body encoded as JSON, the response is
+// decoded into res. A failed status is returned as an error.
+func (api *API) Do(method, path string, body, res interface{}) error {
+	resp, err := api.Open(method, path, body, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if res == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// Open sends a request and returns the response so it can be streamed,
+// failed statuses are returned as an error.
+func (api *API) Open(method, path string, body interface{}, accept string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		var buf bytes.Buffer
+		err := json.NewEncoder(&buf).Encode(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = &buf
+	}
+
+	req, err := http.NewRequest(method, "http://"+api.host()+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+api.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var resErr struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&resErr)
+		if err != nil || resErr.Status != requests.StatusFailed {
+			return nil, fmt.Errorf("%s %s failed with status %d", method, path, resp.StatusCode)
+		}
+
+		return nil, errors.New(resErr.Error)
+	}
+
+	return resp, nil
+}
+
+// Dial opens a WebSocket to the path.
+func (api *API) Dial(path string, query url.Values) (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{NetDial: api.dial}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+api.Token)
+
+	conn, resp, err := dialer.Dial("ws://"+api.host()+path+"?"+query.Encode(), header)
+	if err != nil && resp != nil {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(msg) > 0 {
+			err = errors.New(strings.TrimSpace(string(msg)))
+		}
+	}
+
+	return conn, err
+}
+
+// host is the host used in urls, the socket connections ignore it.
+func (api *API) host() string {
+	if api.Socket != "" {
+		return "bowery"
+	}
+
+	return api.Addr
+}
+
+// dial connects to the unix socket if set, otherwise the address.
+func (api *API) dial(network, addr string) (net.Conn, error) {
+	if api.Socket != "" {
+		return net.Dial("unix", api.Socket)
+	}
+
+	return net.Dial(network, addr)
+}
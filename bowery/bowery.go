@@ -0,0 +1,79 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// cmd is a subcommand, usage is printed when it returns errUsage.
+type cmd struct {
+	Usage string
+	Short string
+	Run   func(api *API, args ...string) error
+}
+
+var cmds = map[string]*cmd{
+	"up":     {"up [dir]", "Create a container for a directory and start syncing it", upCmd},
+	"down":   {"down <container>", "Remove a container and stop syncing it", downCmd},
+	"ls":     {"ls", "List the containers being synced", lsCmd},
+	"status": {"status <container>", "Show a containers forwards, tunnels and terminals", statusCmd},
+	"ssh":    {"ssh <container> [session]", "Open a shell in a container, or reattach to a session", sshCmd},
+	"exec":   {"exec <container> <command...>", "Run a command in a container", execCmd},
+	"logs":   {"logs <container>", "Follow the sync events for a container", logsCmd},
+	"env":    {"env <container>", "Print shell exports for a container", envCmd},
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:32055", "Address of the client api.")
+	socket := flag.String("socket", os.Getenv("BOWERY_SOCKET"), "Unix socket of the client api, used instead of the address if set.")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	command, ok := cmds[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Cmd", args[0], "not found.")
+		os.Exit(1)
+	}
+
+	api, err := NewAPI(*addr, *socket)
+	if err == nil {
+		err = command.Run(api, args[1:]...)
+	}
+	if err == errUsage {
+		fmt.Fprintln(os.Stderr, "Usage: bowery", command.Usage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the flags and subcommands.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: bowery [flags] <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-32s %s\n", cmds[name].Usage, cmds[name].Short)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
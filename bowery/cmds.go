@@ -0,0 +1,299 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Bowery/gopackages/requests"
+	"github.com/Bowery/gopackages/schemas"
+)
+
+// containerRes is the response for a single container.
+type containerRes struct {
+	Container *schemas.Container `json:"container"`
+	Config    *struct {
+		Env map[string]string `json:"env"`
+	} `json:"config"`
+	Forwards []*struct {
+		LocalPort  int `json:"localPort"`
+		RemotePort int `json:"remotePort"`
+	} `json:"forwards"`
+	Tunnels []*struct {
+		RemotePort int    `json:"remotePort"`
+		LocalAddr  string `json:"localAddr"`
+		Connected  bool   `json:"connected"`
+	} `json:"tunnels"`
+	Terminals []*struct {
+		ID        string    `json:"id"`
+		CreatedAt time.Time `json:"createdAt"`
+		Attached  bool      `json:"attached"`
+	} `json:"terminals"`
+	Errors []*syncError `json:"errors"`
+}
+
+// syncError is an error syncing a containers files.
+type syncError struct {
+	Path  string    `json:"path"`
+	Kind  string    `json:"kind"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+func (se syncError) String() string {
+	if se.Kind == "" {
+		return se.Error
+	}
+	if se.Path == "" {
+		return se.Kind + ": " + se.Error
+	}
+
+	return se.Kind + " " + se.Path + ": " + se.Error
+}
+
+// upCmd creates a container for a directory, the current one by default.
+func upCmd(api *API, args ...string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	var res containerRes
+	err = api.Do("POST", "/containers", &requests.ContainerReq{LocalPath: dir}, &res)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(res.Container.ID)
+	return nil
+}
+
+// downCmd removes a container.
+func downCmd(api *API, args ...string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	return api.Do("DELETE", "/containers/"+args[0], nil, nil)
+}
+
+// lsCmd lists the containers being synced.
+func lsCmd(api *API, args ...string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	var res struct {
+		Containers []*schemas.Container `json:"containers"`
+	}
+	err := api.Do("GET", "/containers", nil, &res)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tADDRESS\tPATH")
+	for _, container := range res.Containers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", container.ID, container.Address, container.LocalPath)
+	}
+
+	return tw.Flush()
+}
+
+// statusCmd shows the details of a container.
+func statusCmd(api *API, args ...string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	var res containerRes
+	err := api.Do("GET", "/containers/"+args[0], nil, &res)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%s\n", res.Container.ID)
+	fmt.Fprintf(tw, "Image:\t%s\n", res.Container.ImageID)
+	fmt.Fprintf(tw, "Address:\t%s\n", res.Container.Address)
+	fmt.Fprintf(tw, "Path:\t%s\n", res.Container.LocalPath)
+
+	for _, forward := range res.Forwards {
+		fmt.Fprintf(tw, "Forward:\tlocalhost:%d -> %d\n", forward.LocalPort, forward.RemotePort)
+	}
+	for _, tunnel := range res.Tunnels {
+		state := "disconnected"
+		if tunnel.Connected {
+			state = "connected"
+		}
+
+		fmt.Fprintf(tw, "Tunnel:\t%d -> %s (%s)\n", tunnel.RemotePort, tunnel.LocalAddr, state)
+	}
+	for _, term := range res.Terminals {
+		state := "detached"
+		if term.Attached {
+			state = "attached"
+		}
+
+		fmt.Fprintf(tw, "Terminal:\t%s (%s, started %s)\n", term.ID, state, term.CreatedAt.Local().Format(time.Stamp))
+	}
+	for _, syncErr := range res.Errors {
+		fmt.Fprintf(tw, "Error:\t%s %s\n", syncErr.Time.Local().Format(time.Stamp), syncErr)
+	}
+
+	return tw.Flush()
+}
+
+// execCmd runs a command in a container, exiting with its exit code.
+func execCmd(api *API, args ...string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	body := map[string]string{"command": strings.Join(args[1:], " ")}
+	resp, err := api.Open("POST", "/containers/"+args[0]+"/exec", body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var frame struct {
+			Type  string `json:"type"`
+			Data  string `json:"data"`
+			Code  int    `json:"code"`
+			Error string `json:"error"`
+		}
+		err := decoder.Decode(&frame)
+		if err != nil {
+			return fmt.Errorf("command output ended early: %s", err)
+		}
+
+		switch frame.Type {
+		case "stdout":
+			os.Stdout.WriteString(frame.Data)
+		case "stderr":
+			os.Stderr.WriteString(frame.Data)
+		case "exit":
+			if frame.Error != "" {
+				return fmt.Errorf("command failed: %s", frame.Error)
+			}
+
+			os.Exit(frame.Code)
+		}
+	}
+}
+
+// logsCmd follows the sync events and errors for a container.
+func logsCmd(api *API, args ...string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	query := url.Values{}
+	query.Set("container", args[0])
+	query.Set("type", "sync,error")
+	resp, err := api.Open("GET", "/_/sse?"+query.Encode(), nil, "text/event-stream")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.HasPrefix(line, []byte("data: ")) {
+			continue
+		}
+
+		var ev struct {
+			Type string          `json:"type"`
+			Time time.Time       `json:"time"`
+			Data json.RawMessage `json:"data"`
+		}
+		err := json.Unmarshal(line[len("data: "):], &ev)
+		if err != nil {
+			continue
+		}
+		timestamp := ev.Time.Local().Format(time.Stamp)
+
+		switch ev.Type {
+		case "sync":
+			var data struct {
+				Status string   `json:"status"`
+				Paths  []string `json:"paths"`
+			}
+			json.Unmarshal(ev.Data, &data)
+
+			for _, path := range data.Paths {
+				fmt.Printf("%s %s %s\n", timestamp, data.Status, path)
+			}
+		case "error":
+			var data syncError
+			json.Unmarshal(ev.Data, &data)
+
+			fmt.Printf("%s error %s\n", timestamp, data)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// envCmd prints exports for a containers address and config env, so a
+// shell can use it with eval.
+func envCmd(api *API, args ...string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	var res containerRes
+	err := api.Do("GET", "/containers/"+args[0], nil, &res)
+	if err != nil {
+		return err
+	}
+
+	env := map[string]string{
+		"BOWERY_CONTAINER_ID":   res.Container.ID,
+		"BOWERY_CONTAINER_ADDR": res.Container.Address,
+	}
+	if res.Config != nil {
+		for key, val := range res.Config.Env {
+			env[key] = val
+		}
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("export %s=%s\n", key, shellQuote(env[key]))
+	}
+
+	return nil
+}
+
+// shellQuote quotes a string for a posix shell.
+func shellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Prefixes for terminal WebSocket messages, see client/termsocket.go.
+const (
+	dataPrefix    = "data: "
+	controlPrefix = "control: "
+)
+
+// resizeInterval is how often the terminal size is checked for changes.
+const resizeInterval = 250 * time.Millisecond
+
+// controlMsg is a control message sent over a terminal WebSocket.
+type controlMsg struct {
+	Type  string `json:"type"`
+	Cols  int    `json:"cols,omitempty"`
+	Rows  int    `json:"rows,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// sshCmd opens a shell in a container. If a session id is given and it's
+// still alive it's reattached, otherwise a new shell is started with it.
+func sshCmd(api *API, args ...string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return errors.New("stdin is not a terminal")
+	}
+	cols, rows, err := terminal.GetSize(fd)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("id", args[0])
+	query.Set("cols", strconv.Itoa(cols))
+	query.Set("rows", strconv.Itoa(rows))
+	if len(args) == 2 {
+		query.Set("session", args[1])
+	}
+
+	conn, err := api.Dial("/_/ssh", query)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer terminal.Restore(fd, state)
+
+	writes := make(chan []byte)
+	done := make(chan error, 2)
+
+	// Send stdin as data messages.
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				msg := append([]byte(dataPrefix), buf[:n]...)
+				writes <- msg
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	// Print output until the shell exits and the socket closes.
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+					err = nil
+				}
+				done <- err
+				return
+			}
+
+			if bytes.HasPrefix(data, []byte(dataPrefix)) {
+				os.Stdout.Write(data[len(dataPrefix):])
+				continue
+			}
+			if bytes.HasPrefix(data, []byte(controlPrefix)) {
+				msg := new(controlMsg)
+				if json.Unmarshal(data[len(controlPrefix):], msg) == nil && msg.Type == "error" {
+					fmt.Fprint(os.Stderr, "\r\n", msg.Error, "\r\n")
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(resizeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-writes:
+			err = conn.WriteMessage(websocket.BinaryMessage, msg)
+		case <-ticker.C:
+			newCols, newRows, sizeErr := terminal.GetSize(fd)
+			if sizeErr != nil || (newCols == cols && newRows == rows) {
+				continue
+			}
+			cols, rows = newCols, newRows
+
+			data, _ := json.Marshal(&controlMsg{Type: "resize", Cols: cols, Rows: rows})
+			err = conn.WriteMessage(websocket.BinaryMessage, append([]byte(controlPrefix), data...))
+		case err = <-done:
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Bowery/gopackages/util"
+)
+
+// BoweryConfVersion is the current version of the .bowery file format.
+const BoweryConfVersion = 1
+
+// Defaults used when the .bowery file doesn't set sync options.
+const (
+	defaultSyncInterval   = 500 * time.Millisecond
+	defaultBatchThreshold = 16
+)
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// BoweryConf is the project config stored in .bowery at the root of
+// a containers local path.
+type BoweryConf struct {
+	Version    int               `json:"version"`
+	ImageID    string            `json:"imageID,omitempty"`
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Ignores    []string          `json:"ignores,omitempty"`
+	Sync       *SyncConf         `json:"sync,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	Ports      []int             `json:"ports,omitempty"`
+	Tunnels    []*TunnelConf     `json:"tunnels,omitempty"`
+	Startup    []string          `json:"startup,omitempty"`
+	RemotePath string            `json:"remotePath,omitempty"`
+
+	// Legacy is set if the file was in the pre-versioned token format.
+	Legacy bool `json:"-"`
+}
+
+// SyncConf holds the options for file syncing.
+type SyncConf struct {
+	Interval       string `json:"interval,omitempty"`
+	BatchThreshold int    `json:"batchThreshold,omitempty"`
+}
+
+// TunnelConf describes a port in the container to forward to a local address.
+type TunnelConf struct {
+	RemotePort int    `json:"remotePort"`
+	LocalAddr  string `json:"localAddr"`
+}
+
+// BoweryConfError describes an invalid .bowery file.
+type BoweryConfError struct {
+	Path string
+	Msg  string
+}
+
+func (b *BoweryConfError) Error() string {
+	return b.Path + ": " + b.Msg
+}
+
+// ReadBoweryConf reads and validates the .bowery file in dir. If the
+// file doesn't exist an empty config is returned.
+func ReadBoweryConf(dir string) (*BoweryConf, error) {
+	path := filepath.Join(dir, ".bowery")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &BoweryConf{Version: BoweryConfVersion}, nil
+		}
+
+		return nil, err
+	}
+
+	return ParseBoweryConf(path, data)
+}
+
+// ParseBoweryConf parses and validates the contents of a .bowery file,
+// the legacy token format is also accepted.
+func ParseBoweryConf(path string, data []byte) (*BoweryConf, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return &BoweryConf{
+			Version: BoweryConfVersion,
+			ImageID: util.FindTokenString(string(data)),
+			Legacy:  true,
+		}, nil
+	}
+
+	conf := new(BoweryConf)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(conf)
+	if err != nil {
+		syntaxErr, ok := err.(*json.SyntaxError)
+		if ok {
+			line := bytes.Count(data[:syntaxErr.Offset], []byte("\n")) + 1
+			return nil, &BoweryConfError{Path: path, Msg: fmt.Sprintf("line %d: %s", line, err)}
+		}
+
+		return nil, &BoweryConfError{Path: path, Msg: err.Error()}
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return nil, &BoweryConfError{Path: path, Msg: err.Error()}
+	}
+
+	return conf, nil
+}
+
+// Validate checks the config for invalid values.
+func (conf *BoweryConf) Validate() error {
+	if conf.Version != BoweryConfVersion {
+		return fmt.Errorf("version %d is not supported, expected %d", conf.Version, BoweryConfVersion)
+	}
+
+	if conf.Dockerfile != "" && !isRelPath(conf.Dockerfile) {
+		return fmt.Errorf("dockerfile %q must be a path inside the project", conf.Dockerfile)
+	}
+
+	if conf.RemotePath != "" && !path.IsAbs(conf.RemotePath) {
+		return fmt.Errorf("remotePath %q must be an absolute path", conf.RemotePath)
+	}
+
+	for i, ignore := range conf.Ignores {
+		if ignore == "" || !isRelPath(ignore) {
+			return fmt.Errorf("ignores[%d] %q must be a path inside the project", i, ignore)
+		}
+	}
+
+	if conf.Sync != nil {
+		if conf.Sync.Interval != "" {
+			interval, err := time.ParseDuration(conf.Sync.Interval)
+			if err != nil {
+				return fmt.Errorf("sync.interval %q is not a valid duration", conf.Sync.Interval)
+			}
+			if interval < 100*time.Millisecond {
+				return fmt.Errorf("sync.interval %q must be at least 100ms", conf.Sync.Interval)
+			}
+		}
+
+		if conf.Sync.BatchThreshold < 0 {
+			return fmt.Errorf("sync.batchThreshold %d must be positive", conf.Sync.BatchThreshold)
+		}
+	}
+
+	for key := range conf.Env {
+		if !envKeyRe.MatchString(key) {
+			return fmt.Errorf("env key %q is not a valid variable name", key)
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, port := range conf.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("port %d is out of range", port)
+		}
+		if seen[port] {
+			return fmt.Errorf("port %d is listed more than once", port)
+		}
+		seen[port] = true
+	}
+
+	seen = make(map[int]bool)
+	for i, tunnel := range conf.Tunnels {
+		if tunnel == nil {
+			return fmt.Errorf("tunnels[%d] is empty", i)
+		}
+		if tunnel.RemotePort < 1 || tunnel.RemotePort > 65535 {
+			return fmt.Errorf("tunnels[%d] remotePort %d is out of range", i, tunnel.RemotePort)
+		}
+		if seen[tunnel.RemotePort] {
+			return fmt.Errorf("tunnels[%d] remotePort %d is listed more than once", i, tunnel.RemotePort)
+		}
+		seen[tunnel.RemotePort] = true
+
+		_, _, err := net.SplitHostPort(tunnel.LocalAddr)
+		if err != nil {
+			return fmt.Errorf("tunnels[%d] localAddr %q must be a host:port address", i, tunnel.LocalAddr)
+		}
+	}
+
+	for i, cmd := range conf.Startup {
+		if strings.TrimSpace(cmd) == "" {
+			return fmt.Errorf("startup[%d] is empty", i)
+		}
+	}
+
+	return nil
+}
+
+// SyncInterval returns the time to wait between file walks.
+func (conf *BoweryConf) SyncInterval() time.Duration {
+	if conf == nil || conf.Sync == nil || conf.Sync.Interval == "" {
+		return defaultSyncInterval
+	}
+
+	interval, err := time.ParseDuration(conf.Sync.Interval)
+	if err != nil {
+		return defaultSyncInterval
+	}
+
+	return interval
+}
+
+// BatchThreshold returns the number of changes that trigger a batch upload.
+func (conf *BoweryConf) BatchThreshold() int {
+	if conf == nil || conf.Sync == nil || conf.Sync.BatchThreshold <= 0 {
+		return defaultBatchThreshold
+	}
+
+	return conf.Sync.BatchThreshold
+}
+
+// IgnorePaths returns the absolute ignore paths for the project at dir.
+func (conf *BoweryConf) IgnorePaths(dir string) []string {
+	if conf == nil {
+		return nil
+	}
+	paths := make([]string, 0, len(conf.Ignores))
+
+	for _, ignore := range conf.Ignores {
+		paths = append(paths, filepath.Join(dir, filepath.FromSlash(ignore)))
+	}
+
+	return paths
+}
+
+// Write saves the config to the .bowery file in dir.
+func (conf *BoweryConf) Write(dir string) error {
+	data, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, ".bowery"), append(data, '\n'), 0644)
+}
+
+// isRelPath checks if a path is relative and doesn't leave its root.
+func isRelPath(path string) bool {
+	path = filepath.Clean(filepath.FromSlash(path))
+
+	return !filepath.IsAbs(path) && path != ".." &&
+		!strings.HasPrefix(path, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Bowery/gopackages/util"
+)
+
+func TestParseBoweryConfLegacy(t *testing.T) {
+	data := "0123456789abcdef0123456789abcdef\n"
+
+	conf, err := ParseBoweryConf(".bowery", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !conf.Legacy || conf.Version != BoweryConfVersion {
+		t.Errorf("Expected a legacy config at version %d, got %+v", BoweryConfVersion, conf)
+	}
+	if conf.ImageID != util.FindTokenString(data) {
+		t.Errorf("Expected image id %q, got %q", util.FindTokenString(data), conf.ImageID)
+	}
+}
+
+func TestParseBoweryConfVersioned(t *testing.T) {
+	data := `{
+  "version": 1,
+  "imageID": "abc",
+  "ignores": ["node_modules"],
+  "sync": {"interval": "1s", "batchThreshold": 4},
+  "env": {"PORT": "3000"},
+  "ports": [3000],
+  "startup": ["npm install"],
+  "remotePath": "/app"
+}`
+
+	conf, err := ParseBoweryConf(".bowery", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.Legacy {
+		t.Error("Expected a versioned config")
+	}
+	if conf.ImageID != "abc" || conf.RemotePath != "/app" || conf.Env["PORT"] != "3000" {
+		t.Errorf("Unexpected config %+v", conf)
+	}
+	if conf.SyncInterval() != time.Second || conf.BatchThreshold() != 4 {
+		t.Errorf("Expected a 1s interval and threshold of 4, got %s and %d", conf.SyncInterval(), conf.BatchThreshold())
+	}
+	if len(conf.Startup) != 1 || conf.Startup[0] != "npm install" {
+		t.Errorf("Unexpected startup commands %v", conf.Startup)
+	}
+}
+
+func TestParseBoweryConfInvalid(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"version": 1, "image": "abc"}`, "unknown field"},
+		{`{"version": 1, "sync": {"intervall": "1s"}}`, "unknown field"},
+		{`{"version": 2}`, "version 2 is not supported"},
+		{`{"version": 1,` + "\n" + `"ports": [0]}`, "port 0 is out of range"},
+		{`{"version": 1,` + "\n" + `}`, "line 2"},
+		{`{"version": 1, "remotePath": "app"}`, "must be an absolute path"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseBoweryConf(".bowery", []byte(test.data))
+		if err == nil {
+			t.Errorf("%s: expected an error", test.data)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %s", test.data, test.err, err)
+		}
+	}
+}
+
+func TestReadBoweryConfMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bowery-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf, err := ReadBoweryConf(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Version != BoweryConfVersion || conf.Legacy {
+		t.Errorf("Expected an empty config, got %+v", conf)
+	}
+}
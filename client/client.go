@@ -4,74 +4,188 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
-	"github.com/Bowery/gopackages/config"
-	"github.com/Bowery/gopackages/rollbar"
-	"github.com/Bowery/gopackages/sse"
-	"github.com/Bowery/gopackages/web"
+	"bitbucket.org/kardianos/osext"
+	"github.com/Bowery/desktop/logger"
+	"github.com/Bowery/desktop/reporter"
 )
 
 // Application specific variables.
 var (
 	env              string
 	port             string
-	ssePool          *sse.Pool
+	socket           string
+	recordDir        string
+	pidFile          string
+	shutdownTimeout  time.Duration
+	terminalIdle     time.Duration
+	startTime        time.Time
+	logFile          string
+	logLevel         string
+	logFormat        string
+	eventHub         *EventHub
 	containerManager *ContainerManager
-	rollbarC         *rollbar.Client
+	verifyJobs       *VerifyJobs
+	privacy          *Privacy
+	errorReporter    reporter.ErrorReporter
+	errorReporting   string
 	AbsPath          string
 	VERSION          string // This is set when release_client.sh is ran.
 )
 
 func main() {
+	startTime = time.Now()
 	ver := false
+	daemon := false
+	confPath := ""
 	flag.StringVar(&env, "env", "development", "Mode to run client in.")
-	flag.StringVar(&port, "port", ":32055", "Port to listen on.")
+	flag.StringVar(&port, "port", "127.0.0.1:32055", "Address to listen on, empty to only use the socket.")
+	flag.StringVar(&socket, "socket", "", "Unix socket to also listen on.")
 	flag.BoolVar(&ver, "version", false, "Print the version")
+	flag.StringVar(&recordDir, "record-dir", "", "Directory to record terminal sessions to, disabled if empty.")
+	flag.BoolVar(&sshPasswordFallback, "ssh-password-fallback", false, "Use the container password if ssh key authentication fails.")
+	flag.BoolVar(&daemon, "daemon", false, "Run without the shell, logging for a service manager.")
+	flag.StringVar(&confPath, "config", "", "Config file with options for flags not given.")
+	flag.StringVar(&pidFile, "pidfile", "", "File to write the pid to, disabled if empty.")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time to wait for requests and syncs to finish when shutting down.")
+	flag.DurationVar(&terminalIdle, "terminal-idle-timeout", time.Hour, "Time before detached terminals are closed, 0 keeps them open.")
+	flag.StringVar(&AbsPath, "ui-dir", "", "Directory of the shell ui, defaults to ../ui from the executable.")
+	flag.StringVar(&logFile, "log-file", defaultLogPath(), "File to log to, rotated when it gets large. Daemons log to stderr only by default.")
+	flag.StringVar(&logLevel, "log-level", "info", "Lowest level to log, one of debug, info, warn or error.")
+	flag.StringVar(&logFormat, "log-format", logger.LogfmtFormat, "Log format, logfmt or json.")
+	flag.StringVar(&errorReporting, "error-reporting", "", "Where to report errors, one of rollbar, sentry, file or none. Defaults to the setting in ~/.bowery/error_reporting.json.")
 	flag.Parse()
 	if ver {
 		fmt.Println(VERSION)
 		return
 	}
 
-	ssePool = sse.NewPool()
-	go ssePool.Run()
+	// Daemons are expected to log to the service manager.
+	if daemon {
+		logFile = ""
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "log-file" {
+				logFile = f.Value.String()
+			}
+		})
+	}
+	if confPath != "" {
+		conf, err := ReadDaemonConf(confPath)
+		if err != nil {
+			clientLog.Fatal("Reading config failed", "error", err)
+		}
+		conf.Apply()
+	}
+
+	err := setupLogging(logFile, logLevel, logFormat, daemon)
+	if err != nil {
+		clientLog.Fatal("Setting up logging failed", "error", err)
+	}
+
+	// The executables folder is used since the client may be started from
+	// anywhere, not just by the updater.
+	if AbsPath == "" {
+		dir, err := osext.ExecutableFolder()
+		if err != nil {
+			clientLog.Fatal("Finding the executable failed", "error", err)
+		}
+		AbsPath = filepath.Join(dir, "..", "ui")
+	}
+
+	if pidFile != "" {
+		err := WritePidFile(pidFile)
+		if err != nil {
+			clientLog.Fatal("Writing pidfile failed", "error", err)
+		}
+		defer RemovePidFile(pidFile)
+	}
+
+	eventHub = NewEventHub(eventBufferSize)
+
+	privacy, err = LoadPrivacy(privacyPath())
+	if err != nil {
+		fatalAfterPidFile("Reading privacy settings failed", "error", err)
+	}
 
-	rollbarC = rollbar.NewClient(config.RollbarToken, env)
-	containerManager = NewContainerManager()
-	defer containerManager.Close()
+	errorReporter = setupErrorReporting(errorReporting)
+	containerManager = NewContainerManager(new(kenmareControl), new(delanceyAgent))
+	containerManager.Terminals.RecordDir = recordDir
+	containerManager.Terminals.IdleTimeout = terminalIdle
+	verifyJobs = NewVerifyJobs()
 
 	go func() {
 		for {
 			select {
 			case ev := <-containerManager.Syncer.Event:
 				if len(ev.Paths) == 1 {
-					log.Println("Sync event", ev.Status, "change", ev.Paths[0])
+					syncLog.Info("Sync event", "container", ev.Container.ID, "status", ev.Status, "path", ev.Paths[0])
 				} else {
-					log.Println("Sync event", ev.Status, "changes", len(ev.Paths))
+					syncLog.Info("Sync event", "container", ev.Container.ID, "status", ev.Status, "changes", len(ev.Paths))
 				}
 
-				msg := map[string]interface{}{
-					"event": ev,
-					"type":  "sync",
-				}
-				ssePool.Messages <- msg
+				publishEvent(eventSync, ev.Container.ID, ev)
 			case err := <-containerManager.Syncer.Error:
-				log.Println(err)
+				watchErr, ok := err.(*WatchError)
+				if !ok {
+					syncLog.Error("Sync error", "error", err)
+					go reportError(err, nil)
+					publishEvent(eventError, "", map[string]string{"error": err.Error()})
+					continue
+				}
+
+				syncLog.Warn("Sync error", "container", watchErr.Container.ID, "kind", watchErr.Kind, "path", watchErr.Path, "error", watchErr.Err)
+				containerManager.Errors.Add(watchErr)
+				publishEvent(eventError, watchErr.Container.ID, watchErr)
 			}
 		}
 	}()
 
-	abs, _ := filepath.Abs(filepath.Join(filepath.Dir(os.Args[0]), "../ui/"))
-	AbsPath = abs
+	// Every route requires the token, it's written to a file only the
+	// user can read so the shell can use it.
+	token, err := newToken(tokenPath())
+	if err != nil {
+		fatalAfterPidFile("Writing token failed", "error", err)
+	}
+
+	listeners, err := Listen(port, socket)
+	if err != nil {
+		fatalAfterPidFile("Listening failed", "error", err)
+	}
+
+	server := NewServer(routes, token)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listeners)
+	}()
+	clientLog.Info("Listening", "version", VERSION, "addr", port, "socket", socket)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		clientLog.Info("Shutting down", "signal", sig)
+	case err = <-serveErr:
+		clientLog.Error("Server failed", "error", err)
+		reportError(err, nil)
+	}
 
-	server := web.NewServer(port, []web.Handler{
-		new(web.SlashHandler),
-		new(web.CorsHandler),
-	}, routes)
-	server.AuthHandler = &web.AuthHandler{Auth: web.DefaultAuthHandler}
+	// Stop accepting requests and flush the pending changes to the containers.
+	shutdownErr := shutdown(server, shutdownTimeout, signals)
+	if shutdownErr != nil {
+		clientLog.Error("Shutdown failed", "error", shutdownErr)
+		reportError(shutdownErr, nil)
+		err = shutdownErr
+	}
 
-	server.ListenAndServe()
+	if err != nil {
+		if pidFile != "" {
+			RemovePidFile(pidFile)
+		}
+		os.Exit(1)
+	}
 }
@@ -3,58 +3,131 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 
-	"github.com/Bowery/delancey/delancey"
-	"github.com/Bowery/gopackages/config"
 	"github.com/Bowery/gopackages/schemas"
 	"github.com/Bowery/gopackages/sys"
-	"github.com/Bowery/pusher"
 )
 
 // ContainerManager manages all active containers as well as
 // the file syncing between the local and remote machines.
 type ContainerManager struct {
 	Containers map[string]*schemas.Container
+	Configs    map[string]*BoweryConf
+	Control    Control
 	Syncer     *Syncer
+	SSH        *SSHPool
+	Forwards   *ForwardManager
+	Tunnels    *TunnelManager
+	Terminals  *TerminalManager
+	Errors     *SyncErrors
 }
 
-// NewContainerManager creates a new ContainerManager.
-func NewContainerManager() *ContainerManager {
-	return &ContainerManager{
+// NewContainerManager creates a new ContainerManager using the given
+// control plane and agent.
+func NewContainerManager(control Control, agent Agent) *ContainerManager {
+	cm := &ContainerManager{
 		Containers: make(map[string]*schemas.Container),
-		Syncer:     NewSyncer(),
+		Configs:    make(map[string]*BoweryConf),
+		Control:    control,
+		Syncer:     NewSyncer(agent),
+		Errors:     NewSyncErrors(),
 	}
+	cm.SSH = NewSSHPool()
+	cm.Forwards = NewForwardManager(cm.SSH)
+	cm.Tunnels = NewTunnelManager(cm.SSH)
+	cm.Terminals = NewTerminalManager(cm.SSH)
+
+	return cm
 }
 
-// Add adds a container and initiates file syncing.
-func (cm *ContainerManager) Add(container *schemas.Container) {
-	go func() {
-		conn, err := pusher.New(config.PusherKey)
-		if err != nil {
-			return
-		}
-		channel := conn.Channel("container-" + container.ID)
-		ev := channel.Bind("created")
-		data := (<-ev).(string)
-		conn.Disconnect()
+// Add adds a container and initiates file syncing using the
+// options from its .bowery config, conf may be nil to use the defaults.
+func (cm *ContainerManager) Add(container *schemas.Container, conf *BoweryConf) {
+	if conf == nil {
+		conf = &BoweryConf{Version: BoweryConfVersion}
+	}
 
-		cont := new(schemas.Container)
-		err = json.Unmarshal([]byte(data), cont)
+	go func() {
+		cont, err := cm.Control.WaitCreated(container.ID)
 		if err != nil {
 			return
 		}
 
 		cont.LocalPath = container.LocalPath
 		cm.Containers[container.ID] = cont
-		cm.Syncer.Watch(cont)
-		delancey.UploadSSH(cont, filepath.Join(os.Getenv(sys.HomeVar), ".ssh"))
+		publishEvent(eventContainer, cont.ID, map[string]interface{}{
+			"status":    "ready",
+			"container": cont,
+		})
+		watcher := cm.Syncer.Watch(cont, conf)
+		cm.Syncer.Agent.UploadSSH(cont, filepath.Join(os.Getenv(sys.HomeVar), ".ssh"))
+
+		// Run the startup commands once the files are in the container.
+		if len(conf.Startup) > 0 {
+			go func() {
+				<-watcher.Uploaded()
+				if watcher.State() != watcherFailed {
+					cm.runStartup(cont, conf)
+				}
+			}()
+		}
+
+		// Forward the ports declared in the config to the same local ports.
+		for _, port := range conf.Ports {
+			_, err := cm.Forwards.Add(cont, port, port)
+			if err != nil {
+				forwardLog.Warn("Forward failed", "container", cont.ID, "port", port, "error", err)
+			}
+		}
+
+		// Open the tunnels declared in the config.
+		for _, tunnel := range conf.Tunnels {
+			_, err := cm.Tunnels.Add(cont, tunnel.RemotePort, tunnel.LocalAddr)
+			if err != nil {
+				tunnelLog.Warn("Tunnel failed", "container", cont.ID, "port", tunnel.RemotePort, "error", err)
+			}
+		}
 	}()
 
 	cm.Containers[container.ID] = container
+	cm.Configs[container.ID] = conf
+}
+
+// runStartup runs the startup commands from the config in order with its
+// env, stopping at the first that fails.
+func (cm *ContainerManager) runStartup(container *schemas.Container, conf *BoweryConf) {
+	for _, command := range conf.Startup {
+		client, err := cm.SSH.Get(container)
+		if err != nil {
+			clientLog.Warn("Startup command failed", "container", container.ID, "command", command, "error", err)
+			return
+		}
+
+		session, err := client.NewSession()
+		if err != nil {
+			clientLog.Warn("Startup command failed", "container", container.ID, "command", command, "error", err)
+			return
+		}
+
+		out, err := session.CombinedOutput(execCommand(&ExecReq{Command: command}, conf.Env))
+		session.Close()
+		if err != nil {
+			clientLog.Warn("Startup command failed", "container", container.ID, "command", command, "output", string(out), "error", err)
+			publishEvent(eventContainer, container.ID, map[string]string{
+				"status":  "startup-failed",
+				"command": command,
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		clientLog.Info("Startup command finished", "container", container.ID, "command", command)
+	}
+
+	publishEvent(eventContainer, container.ID, map[string]string{"status": "started"})
 }
 
 // RemoveByID removes a container with the specified id and
@@ -66,11 +139,24 @@ func (cm *ContainerManager) RemoveByID(id string) error {
 	}
 
 	cm.Syncer.Remove(container)
+	cm.Forwards.RemoveAll(id)
+	cm.Tunnels.RemoveAll(id)
+	cm.Terminals.RemoveAll(id)
+	cm.SSH.Reset(id)
+	cm.Errors.Clear(id)
 	delete(cm.Containers, id)
+	delete(cm.Configs, id)
 	return nil
 }
 
-// Close closes the file syncer.
+// Close flushes pending file changes and closes the file syncer, forwards,
+// tunnels, terminals and ssh connections.
 func (cm *ContainerManager) Close() error {
-	return cm.Syncer.Close()
+	err := cm.Syncer.Close()
+	cm.Forwards.Close()
+	cm.Tunnels.Close()
+	cm.Terminals.Close()
+	cm.SSH.Close()
+
+	return err
 }
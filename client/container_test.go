@@ -0,0 +1,145 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Bowery/delancey/delancey"
+)
+
+// waitEvent waits for a sync event with the status, failing on sync errors.
+func waitEvent(t *testing.T, syncer *Syncer, status string) *Event {
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case ev := <-syncer.Event:
+			if ev.Status == status {
+				return ev
+			}
+		case err := <-syncer.Error:
+			t.Fatal("Sync error:", err)
+		case <-timeout:
+			t.Fatal("Timed out waiting for", status, "event")
+		}
+	}
+}
+
+// waitState polls a watcher until it's in the state.
+func waitState(t *testing.T, watcher *Watcher, state string) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for watcher.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for watcher to be", state)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestContainerCreateSyncDelete(t *testing.T) {
+	eventHub = NewEventHub(eventBufferSize)
+	dir, err := ioutil.TempDir("", "bowery-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "initial.txt"), []byte("initial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	control := NewFakeControl()
+	agent := NewFakeAgent()
+	cm := NewContainerManager(control, agent)
+	conf := &BoweryConf{Version: BoweryConfVersion, Sync: &SyncConf{Interval: "10ms"}}
+
+	container, err := control.CreateContainer("", dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.Add(container, conf)
+
+	// The initial upload sends the existing files.
+	waitEvent(t, cm.Syncer, delancey.UploadFinishStatus)
+	if string(agent.Files(container.ID)["initial.txt"]) != "initial" {
+		t.Fatal("Initial file wasn't uploaded:", agent.Files(container.ID))
+	}
+
+	// Changes are synced once the watcher has taken the initial stats.
+	watcher, _ := cm.Syncer.GetWatcher(container)
+	waitState(t, watcher, watcherWatching)
+	err = ioutil.WriteFile(filepath.Join(dir, "created.txt"), []byte("created"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, cm.Syncer, delancey.CreateStatus)
+	if string(agent.Files(container.ID)["created.txt"]) != "created" {
+		t.Fatal("Created file wasn't synced:", agent.Files(container.ID))
+	}
+
+	err = os.Remove(filepath.Join(dir, "initial.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, cm.Syncer, delancey.DeleteStatus)
+	if _, ok := agent.Files(container.ID)["initial.txt"]; ok {
+		t.Fatal("Deleted file wasn't removed:", agent.Files(container.ID))
+	}
+
+	// Deleting stops syncing and removes the container.
+	err = cm.RemoveByID(container.ID)
+	if err == nil {
+		err = control.DeleteContainer(container.ID)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cm.Containers[container.ID]; ok {
+		t.Error("Container wasn't removed from the manager")
+	}
+	if _, ok := control.Containers[container.ID]; ok {
+		t.Error("Container wasn't removed from the control plane")
+	}
+
+	// Removing waits for the sync loop to return, so nothing is synced after.
+	if state := watcher.State(); state != watcherStopped {
+		t.Error("Expected the watcher to be stopped, got", state)
+	}
+	if _, missing := cm.Syncer.GetWatcher(container); !missing {
+		t.Error("Watcher wasn't removed from the syncer")
+	}
+}
+
+func TestContainerAddNilConf(t *testing.T) {
+	eventHub = NewEventHub(eventBufferSize)
+	dir, err := ioutil.TempDir("", "bowery-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	control := NewFakeControl()
+	cm := NewContainerManager(control, NewFakeAgent())
+	container, err := control.CreateContainer("", dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm.Add(container, nil)
+	waitEvent(t, cm.Syncer, delancey.UploadFinishStatus)
+	if cm.Configs[container.ID] == nil {
+		t.Error("Expected the default config to be stored")
+	}
+
+	err = cm.RemoveByID(container.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
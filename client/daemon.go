@@ -0,0 +1,143 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DaemonConf is the config file used when running without the shell. Its
+// values are used for any flags not given on the command line, empty
+// values are ignored.
+type DaemonConf struct {
+	Env                 string `json:"env"`
+	Port                string `json:"port"`
+	Socket              string `json:"socket"`
+	RecordDir           string `json:"recordDir"`
+	PidFile             string `json:"pidFile"`
+	UIDir               string `json:"uiDir"`
+	ShutdownTimeout     string `json:"shutdownTimeout"`
+	TerminalIdleTimeout string `json:"terminalIdleTimeout"`
+	LogFile             string `json:"logFile"`
+	LogLevel            string `json:"logLevel"`
+	LogFormat           string `json:"logFormat"`
+	ErrorReporting      string `json:"errorReporting"`
+	SSHPasswordFallback *bool  `json:"sshPasswordFallback"`
+}
+
+// ReadDaemonConf reads the daemon config file at path.
+func ReadDaemonConf(path string) (*DaemonConf, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := new(DaemonConf)
+	err = json.Unmarshal(data, conf)
+	if err == nil && conf.ShutdownTimeout != "" {
+		_, err = time.ParseDuration(conf.ShutdownTimeout)
+	}
+	if err == nil && conf.TerminalIdleTimeout != "" {
+		_, err = time.ParseDuration(conf.TerminalIdleTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return conf, nil
+}
+
+// Apply sets the options for the flags that weren't given.
+func (conf *DaemonConf) Apply() {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	set := func(name string, dest *string, val string) {
+		if !given[name] && val != "" {
+			*dest = val
+		}
+	}
+	set("env", &env, conf.Env)
+	set("port", &port, conf.Port)
+	set("socket", &socket, conf.Socket)
+	set("record-dir", &recordDir, conf.RecordDir)
+	set("pidfile", &pidFile, conf.PidFile)
+	set("ui-dir", &AbsPath, conf.UIDir)
+	set("log-file", &logFile, conf.LogFile)
+	set("log-level", &logLevel, conf.LogLevel)
+	set("log-format", &logFormat, conf.LogFormat)
+	set("error-reporting", &errorReporting, conf.ErrorReporting)
+
+	if !given["shutdown-timeout"] && conf.ShutdownTimeout != "" {
+		shutdownTimeout, _ = time.ParseDuration(conf.ShutdownTimeout)
+	}
+	if !given["terminal-idle-timeout"] && conf.TerminalIdleTimeout != "" {
+		terminalIdle, _ = time.ParseDuration(conf.TerminalIdleTimeout)
+	}
+	if !given["ssh-password-fallback"] && conf.SSHPasswordFallback != nil {
+		sshPasswordFallback = *conf.SSHPasswordFallback
+	}
+}
+
+// WritePidFile writes the current pid to path, failing if the file belongs
+// to a process that's still running.
+func WritePidFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid != os.Getpid() && isRunning(pid) {
+			return fmt.Errorf("client is already running with pid %d, from %s", pid, path)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// fatalAfterPidFile removes the pidfile and logs a fatal error, for
+// failures after the pidfile is written since Fatal skips deferred calls.
+func fatalAfterPidFile(msg string, keyvals ...interface{}) {
+	if pidFile != "" {
+		RemovePidFile(pidFile)
+	}
+
+	clientLog.Fatal(msg, keyvals...)
+}
+
+// RemovePidFile removes the pidfile if it has the current pid.
+func RemovePidFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return err
+	}
+
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+
+	return os.Remove(path)
+}
+
+// isRunning checks if a process with the pid is running.
+func isRunning(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return proc.Signal(syscall.Signal(0)) == nil
+}
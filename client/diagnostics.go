@@ -0,0 +1,269 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Bowery/desktop/logger"
+	"github.com/Bowery/desktop/reporter"
+	"github.com/Bowery/gopackages/config"
+	"github.com/Bowery/gopackages/schemas"
+)
+
+// Health is the cheap self check, it doesn't touch the network.
+type Health struct {
+	Version        string `json:"version"`
+	Uptime         string `json:"uptime"`
+	Goroutines     int    `json:"goroutines"`
+	ContainerCount int    `json:"containerCount"`
+	Watchers       int    `json:"watchers"`
+}
+
+// Check is the result of checking an address can be reached.
+type Check struct {
+	Addr    string `json:"addr,omitempty"`
+	OK      bool   `json:"ok"`
+	Latency string `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DiskSpace is the space on the disk holding a path.
+type DiskSpace struct {
+	Free  uint64 `json:"free"`
+	Total uint64 `json:"total"`
+	Error string `json:"error,omitempty"`
+}
+
+// ContainerDiagnostics are the checks for a single container.
+type ContainerDiagnostics struct {
+	ID         string     `json:"id"`
+	LocalPath  string     `json:"localPath"`
+	Agent      *Check     `json:"agent"`
+	SSH        *Check     `json:"ssh"`
+	Sync       string     `json:"sync"`
+	SyncErrors int        `json:"syncErrors"`
+	Disk       *DiskSpace `json:"disk"`
+}
+
+// Diagnostics is the full self check.
+type Diagnostics struct {
+	Health
+	OS         string                  `json:"os"`
+	Arch       string                  `json:"arch"`
+	GoVersion  string                  `json:"goVersion"`
+	Time       time.Time               `json:"time"`
+	Kenmare    *Check                  `json:"kenmare"`
+	Containers []*ContainerDiagnostics `json:"containers"`
+}
+
+// GetHealth returns the current health.
+func GetHealth() *Health {
+	watchers := 0
+	for _, watcher := range containerManager.Syncer.Watchers {
+		if watcher != nil {
+			watchers++
+		}
+	}
+
+	return &Health{
+		Version:        VERSION,
+		Uptime:         time.Since(startTime).String(),
+		Goroutines:     runtime.NumGoroutine(),
+		ContainerCount: len(containerManager.Containers),
+		Watchers:       watchers,
+	}
+}
+
+// GetDiagnostics runs the checks for kenmare and every container
+// concurrently.
+func GetDiagnostics() *Diagnostics {
+	var wg sync.WaitGroup
+	diag := &Diagnostics{
+		Health:     *GetHealth(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		GoVersion:  runtime.Version(),
+		Time:       time.Now(),
+		Containers: make([]*ContainerDiagnostics, 0, len(containerManager.Containers)),
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		diag.Kenmare = runCheck(config.KenmareAddr, containerManager.Control.Ping)
+	}()
+
+	for _, container := range containerManager.Containers {
+		cd := &ContainerDiagnostics{
+			ID:         container.ID,
+			LocalPath:  container.LocalPath,
+			Sync:       watcherStopped,
+			SyncErrors: len(containerManager.Errors.List(container.ID)),
+		}
+		if watcher, missing := containerManager.Syncer.GetWatcher(container); !missing {
+			cd.Sync = watcher.State()
+		}
+		diag.Containers = append(diag.Containers, cd)
+
+		wg.Add(1)
+		go func(container *schemas.Container, cd *ContainerDiagnostics) {
+			defer wg.Done()
+			cd.Agent = runCheck(net.JoinHostPort(container.Address, config.DelanceyProdPort), func() error {
+				return containerManager.Syncer.Agent.Ping(container)
+			})
+
+			sshAddr := net.JoinHostPort(container.Address, config.DelanceySSHPort)
+			cd.SSH = runCheck(sshAddr, func() error {
+				if container.Address == "" {
+					return fmt.Errorf("container %s isn't running yet", container.ID)
+				}
+
+				return dialCheck(sshAddr)
+			})
+
+			cd.Disk = new(DiskSpace)
+			free, total, err := diskSpace(container.LocalPath)
+			if err != nil {
+				cd.Disk.Error = err.Error()
+			}
+			cd.Disk.Free = free
+			cd.Disk.Total = total
+		}(container, cd)
+	}
+
+	wg.Wait()
+	sort.Sort(containerDiagnosticsByID(diag.Containers))
+	return diag
+}
+
+// runCheck runs a reachability check timing how long it takes.
+func runCheck(addr string, check func() error) *Check {
+	start := time.Now()
+	err := check()
+	if err != nil {
+		return &Check{Addr: addr, Error: err.Error()}
+	}
+
+	return &Check{Addr: addr, OK: true, Latency: time.Since(start).String()}
+}
+
+// containerDiagnosticsByID sorts container diagnostics by id.
+type containerDiagnosticsByID []*ContainerDiagnostics
+
+func (cds containerDiagnosticsByID) Len() int           { return len(cds) }
+func (cds containerDiagnosticsByID) Less(i, j int) bool { return cds[i].ID < cds[j].ID }
+func (cds containerDiagnosticsByID) Swap(i, j int)      { cds[i], cds[j] = cds[j], cds[i] }
+
+// WriteSupportBundle writes a .tar.gz with the diagnostics, recent logs,
+// the log file, metrics, sync errors and a goroutine dump, all scrubbed
+// of credentials and personal paths.
+func WriteSupportBundle(w io.Writer, diag *Diagnostics) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	now := time.Now()
+
+	add := func(name string, contents []byte) error {
+		err := tarWriter.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(contents)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tarWriter.Write(contents)
+		return err
+	}
+
+	var buf bytes.Buffer
+	files := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{"diagnostics.json", func(w io.Writer) error {
+			return writeIndentJSON(w, diag)
+		}},
+		{"errors.json", func(w io.Writer) error {
+			errs := make(map[string][]*WatchError)
+			for id := range containerManager.Containers {
+				errs[id] = containerManager.Errors.List(id)
+			}
+
+			return writeIndentJSON(w, errs)
+		}},
+		{"recent.log", func(w io.Writer) error {
+			for _, entry := range logger.Recent(0, logger.DebugLevel, "") {
+				_, err := io.WriteString(w, entry.Line()+"\n")
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}},
+		{"client.log", func(w io.Writer) error {
+			if logFile == "" {
+				return nil
+			}
+
+			contents, err := ioutil.ReadFile(logFile)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			_, err = w.Write(contents)
+			return err
+		}},
+		{"metrics.txt", WriteMetrics},
+		{"goroutines.txt", func(w io.Writer) error {
+			return pprof.Lookup("goroutine").WriteTo(w, 2)
+		}},
+	}
+
+	// Everything is scrubbed like reported errors, the logs include
+	// commands and their output.
+	scrubber := reporter.NewScrubber(nil)
+	for _, file := range files {
+		buf.Reset()
+		err := file.write(&buf)
+		if err == nil {
+			err = add(file.name, []byte(scrubber.Scrub(buf.String())))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tarWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+// writeIndentJSON writes indented JSON for humans reading the bundle.
+func writeIndentJSON(w io.Writer, val interface{}) error {
+	contents, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(contents, '\n'))
+	return err
+}
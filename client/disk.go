@@ -0,0 +1,22 @@
+// Copyright 2015 Bowery, Inc.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// diskSpace returns the free and total bytes on the disk containing path.
+func diskSpace(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
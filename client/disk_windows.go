@@ -0,0 +1,28 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskSpace returns the free and total bytes on the disk containing path.
+func diskSpace(path string) (uint64, uint64, error) {
+	var free, total, totalFree uint64
+
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ret, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&totalFree)))
+	if ret == 0 {
+		return 0, 0, err
+	}
+
+	return free, total, nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of events sent on the event stream.
+const (
+	eventSync      = "sync"
+	eventContainer = "container"
+	eventError     = "error"
+	eventReset     = "reset"
+)
+
+// eventBufferSize is the number of events kept for clients to replay.
+const eventBufferSize = 1024
+
+// sseHeartbeatInterval is how often a comment is sent to idle event
+// streams, so proxies and clients don't time out the connection.
+const sseHeartbeatInterval = 15 * time.Second
+
+// StreamEvent is an event sent to the event stream clients.
+type StreamEvent struct {
+	ID          uint64      `json:"id"`
+	Type        string      `json:"type"`
+	ContainerID string      `json:"containerID,omitempty"`
+	Time        time.Time   `json:"time"`
+	Data        interface{} `json:"data"`
+}
+
+// WriteTo writes the event in the server-sent events format.
+func (ev *StreamEvent) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return int64(n), err
+}
+
+// EventLog assigns ids to events and keeps the latest ones in a ring
+// buffer so reconnecting clients can replay what they missed.
+type EventLog struct {
+	ring   []*StreamEvent
+	start  int
+	count  int
+	lastID uint64
+	mutex  sync.Mutex
+}
+
+// NewEventLog creates an event log keeping size events.
+func NewEventLog(size int) *EventLog {
+	return &EventLog{ring: make([]*StreamEvent, size)}
+}
+
+// Add creates an event with the next id and adds it to the buffer.
+func (el *EventLog) Add(typ, containerID string, data interface{}) *StreamEvent {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	el.lastID++
+	ev := &StreamEvent{
+		ID:          el.lastID,
+		Type:        typ,
+		ContainerID: containerID,
+		Time:        time.Now(),
+		Data:        data,
+	}
+
+	if el.count < len(el.ring) {
+		el.ring[(el.start+el.count)%len(el.ring)] = ev
+		el.count++
+	} else {
+		el.ring[el.start] = ev
+		el.start = (el.start + 1) % len(el.ring)
+	}
+
+	return ev
+}
+
+// Since returns the buffered events after id. If some of those events are
+// no longer buffered, or the id is from before the client restarted, all
+// the buffered events are returned along with false.
+func (el *EventLog) Since(id uint64) ([]*StreamEvent, bool) {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	events := make([]*StreamEvent, 0)
+	stale := id > el.lastID
+	if stale {
+		id = 0
+	}
+	complete := !stale && id == el.lastID
+	for i := 0; i < el.count; i++ {
+		ev := el.ring[(el.start+i)%len(el.ring)]
+		if ev.ID == id+1 && !stale {
+			complete = true
+		}
+		if ev.ID > id {
+			events = append(events, ev)
+		}
+	}
+
+	return events, complete
+}
+
+// LastID returns the id of the latest event.
+func (el *EventLog) LastID() uint64 {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	return el.lastID
+}
+
+// EventFilter limits the events sent to a client by container and type,
+// nil sets match everything.
+type EventFilter struct {
+	Containers map[string]bool
+	Types      map[string]bool
+	mutex      sync.RWMutex
+}
+
+// NewEventFilter creates a filter from the comma separated container and
+// type query params.
+func NewEventFilter(req *http.Request) *EventFilter {
+	return &EventFilter{
+		Containers: splitSet(req.FormValue("container")),
+		Types:      splitSet(req.FormValue("type")),
+	}
+}
+
+// Match checks if an event passes the filter. Events without a container
+// pass the container filter.
+func (filter *EventFilter) Match(ev *StreamEvent) bool {
+	filter.mutex.RLock()
+	defer filter.mutex.RUnlock()
+
+	if filter.Types != nil && !filter.Types[ev.Type] {
+		return false
+	}
+
+	return filter.Containers == nil || ev.ContainerID == "" || filter.Containers[ev.ContainerID]
+}
+
+// AddContainer adds a container to the filter, if the filter matched all
+// containers it now only matches the one given.
+func (filter *EventFilter) AddContainer(id string) {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+
+	if filter.Containers == nil {
+		filter.Containers = make(map[string]bool)
+	}
+	filter.Containers[id] = true
+}
+
+// RemoveContainer removes a container from the filter.
+func (filter *EventFilter) RemoveContainer(id string) error {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+
+	if !filter.Containers[id] {
+		return fmt.Errorf("not subscribed to container %s", id)
+	}
+	delete(filter.Containers, id)
+
+	return nil
+}
+
+// lastEventID gets the id of the last event a client received, from the
+// header EventSource sends on reconnect or the query for other clients.
+// False is returned if the client didn't send one.
+func lastEventID(req *http.Request) (uint64, bool) {
+	val := req.Header.Get("Last-Event-ID")
+	if val == "" {
+		val = req.FormValue("lastEventID")
+	}
+	if val == "" {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(val, 10, 64)
+	return id, err == nil
+}
+
+// publishEvent sends an event to the clients, it never blocks on them.
+func publishEvent(typ, containerID string, data interface{}) {
+	eventHub.Publish(typ, containerID, data)
+}
+
+// splitSet splits a comma separated list into a set, nil is returned if
+// the list is empty.
+func splitSet(list string) map[string]bool {
+	var set map[string]bool
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if set == nil {
+			set = make(map[string]bool)
+		}
+		set[item] = true
+	}
+
+	return set
+}
@@ -0,0 +1,178 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Types of messages sent over an event WebSocket, other than events.
+const (
+	eventSubscribe    = "subscribe"
+	eventUnsubscribe  = "unsubscribe"
+	eventSubscribed   = "subscribed"
+	eventUnsubscribed = "unsubscribed"
+	eventRejected     = "rejected"
+)
+
+// EventSocketMsg is a message to or from an event WebSocket, used to
+// change the containers the events are sent for.
+type EventSocketMsg struct {
+	Type      string `json:"type"`
+	Container string `json:"container,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// EventSocket sends a subscribers events over a WebSocket, applying the
+// subscribe and unsubscribe messages it receives to the subscribers filter.
+type EventSocket struct {
+	sub      *Subscriber
+	conn     *websocket.Conn
+	messages chan *EventSocketMsg
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewEventSocket starts reading messages from a WebSocket connection.
+func NewEventSocket(conn *websocket.Conn, sub *Subscriber) *EventSocket {
+	socket := &EventSocket{
+		sub:      sub,
+		conn:     conn,
+		messages: make(chan *EventSocketMsg),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	})
+
+	go socket.read()
+	return socket
+}
+
+// Run sends the events until the connection closes or the client shuts
+// down. Events after lastID are replayed first if replay is true.
+func (socket *EventSocket) Run(lastID uint64, replay bool) error {
+	defer socket.conn.Close()
+	defer close(socket.stopped)
+
+	sent := socket.sub.LastID
+	if replay {
+		var err error
+		sent, err = eventHub.Replay(socket.sub, lastID, socket.Send)
+		if err != nil {
+			return err
+		}
+	}
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+
+		select {
+		case <-closing:
+			socket.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "client is shutting down"))
+			return nil
+		case <-socket.done:
+			return nil
+		case <-ping.C:
+			err = socket.write(websocket.PingMessage, nil)
+		case msg := <-socket.messages:
+			err = socket.handle(msg)
+		case <-socket.sub.Lagged:
+			sent, err = eventHub.Replay(socket.sub, sent, socket.Send)
+		case ev := <-socket.sub.Events:
+			if ev.ID <= sent {
+				continue
+			}
+
+			sent = ev.ID
+			err = socket.Send(ev)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Send sends an event as a JSON message.
+func (socket *EventSocket) Send(ev *StreamEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	return socket.write(websocket.TextMessage, data)
+}
+
+// handle applies a subscribe or unsubscribe message and replies to it.
+func (socket *EventSocket) handle(msg *EventSocketMsg) error {
+	var err error
+
+	reply := &EventSocketMsg{Container: msg.Container}
+	switch {
+	case msg.Error != "":
+		err = errors.New(msg.Error)
+	case msg.Container == "" && (msg.Type == eventSubscribe || msg.Type == eventUnsubscribe):
+		err = errors.New("a container is required")
+	case msg.Type == eventSubscribe:
+		socket.sub.Filter.AddContainer(msg.Container)
+		reply.Type = eventSubscribed
+	case msg.Type == eventUnsubscribe:
+		err = socket.sub.Filter.RemoveContainer(msg.Container)
+		reply.Type = eventUnsubscribed
+	default:
+		err = fmt.Errorf("message type %q is not supported", msg.Type)
+	}
+	if err != nil {
+		reply.Type = eventRejected
+		reply.Error = err.Error()
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	return socket.write(websocket.TextMessage, data)
+}
+
+// write writes a message with the write timeout, only Run writes so no
+// locking is needed.
+func (socket *EventSocket) write(typ int, data []byte) error {
+	socket.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return socket.conn.WriteMessage(typ, data)
+}
+
+// read reads messages until the connection closes or times out, invalid
+// messages are rejected.
+func (socket *EventSocket) read() {
+	defer close(socket.done)
+
+	for {
+		_, data, err := socket.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		socket.conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+
+		msg := new(EventSocketMsg)
+		err = json.Unmarshal(data, msg)
+		if err != nil {
+			msg = &EventSocketMsg{Error: "invalid message: " + err.Error()}
+		}
+
+		select {
+		case socket.messages <- msg:
+		case <-socket.stopped:
+			return
+		}
+	}
+}
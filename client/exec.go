@@ -0,0 +1,125 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ExecReq is the body of a request to run a command in a container.
+type ExecReq struct {
+	Command string            `json:"command"`
+	Dir     string            `json:"dir"`
+	Env     map[string]string `json:"env"`
+}
+
+// ExecFrame is a single piece of a commands streamed output.
+type ExecFrame struct {
+	Type  string `json:"type"`
+	Data  string `json:"data,omitempty"`
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// Types of exec frames.
+const (
+	execStdout = "stdout"
+	execStderr = "stderr"
+	execExit   = "exit"
+)
+
+// execStream writes frames to a response as newline delimited JSON, or as
+// server-sent events if the client asked for them.
+type execStream struct {
+	rw    http.ResponseWriter
+	isSSE bool
+	mutex sync.Mutex
+}
+
+// newExecStream sets the response headers for the stream format requested.
+func newExecStream(rw http.ResponseWriter, req *http.Request) *execStream {
+	isSSE := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+
+	if isSSE {
+		rw.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		rw.Header().Set("Content-Type", "application/json")
+	}
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+
+	return &execStream{rw: rw, isSSE: isSSE}
+}
+
+// Send writes a frame and flushes it to the client.
+func (stream *execStream) Send(frame *ExecFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if stream.isSSE {
+		_, err = fmt.Fprintf(stream.rw, "event: %s\ndata: %s\n\n", frame.Type, data)
+	} else {
+		_, err = fmt.Fprintf(stream.rw, "%s\n", data)
+	}
+	if f, ok := stream.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return err
+}
+
+// execWriter sends everything written to it as frames of a type.
+type execWriter struct {
+	stream *execStream
+	typ    string
+}
+
+func (ew *execWriter) Write(b []byte) (int, error) {
+	err := ew.stream.Send(&ExecFrame{Type: ew.typ, Data: string(b)})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// execCommand builds the shell command that runs req in its dir and env.
+func execCommand(req *ExecReq, env map[string]string) string {
+	vars := make(map[string]string)
+	for key, val := range env {
+		vars[key] = val
+	}
+	for key, val := range req.Env {
+		vars[key] = val
+	}
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cmd := ""
+	for _, key := range keys {
+		cmd += "export " + key + "=" + shellQuote(vars[key]) + "; "
+	}
+	if req.Dir != "" {
+		cmd += "cd " + shellQuote(req.Dir) + " && "
+	}
+
+	return cmd + req.Command
+}
+
+// shellQuote quotes a string for use as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
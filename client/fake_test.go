@@ -0,0 +1,287 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Bowery/delancey/delancey"
+	"github.com/Bowery/gopackages/schemas"
+)
+
+// FakeControl is an in-process Control that keeps containers in memory.
+type FakeControl struct {
+	Containers map[string]*schemas.Container
+	Projects   map[string]*schemas.Project
+	Saved      map[string]int
+	nextID     int
+	mutex      sync.Mutex
+}
+
+// NewFakeControl creates a FakeControl.
+func NewFakeControl() *FakeControl {
+	return &FakeControl{
+		Containers: make(map[string]*schemas.Container),
+		Projects:   make(map[string]*schemas.Project),
+		Saved:      make(map[string]int),
+	}
+}
+
+// GetProject retrieves a stored project.
+func (fc *FakeControl) GetProject(id string) (*schemas.Project, error) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	project, ok := fc.Projects[id]
+	if !ok {
+		return nil, fmt.Errorf("no project with id %s exists", id)
+	}
+
+	return project, nil
+}
+
+// UpdateProject stores the project.
+func (fc *FakeControl) UpdateProject(addr string, project *schemas.Project) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.Projects[project.ID] = project
+	return nil
+}
+
+// CreateContainer creates a container, generating an image id if needed.
+func (fc *FakeControl) CreateContainer(imageID, localPath, dockerfile string) (*schemas.Container, error) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.nextID++
+	id := strconv.Itoa(fc.nextID)
+	if imageID == "" {
+		imageID = "image-" + id
+	}
+
+	container := &schemas.Container{
+		ID:        "container-" + id,
+		ImageID:   imageID,
+		LocalPath: localPath,
+		Address:   "127.0.0.1",
+	}
+	fc.Containers[container.ID] = container
+	return container, nil
+}
+
+// UpdateCollaborator does nothing.
+func (fc *FakeControl) UpdateCollaborator(imageID string, collaborator *schemas.Collaborator) error {
+	return nil
+}
+
+// DeleteContainer removes a stored container.
+func (fc *FakeControl) DeleteContainer(id string) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	if _, ok := fc.Containers[id]; !ok {
+		return fmt.Errorf("no container with id %s exists", id)
+	}
+
+	delete(fc.Containers, id)
+	return nil
+}
+
+// SaveContainer counts the saves for a container.
+func (fc *FakeControl) SaveContainer(id, addr string) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	if _, ok := fc.Containers[id]; !ok {
+		return fmt.Errorf("no container with id %s exists", id)
+	}
+
+	fc.Saved[id]++
+	return nil
+}
+
+// Export returns placeholder export commands.
+func (fc *FakeControl) Export(imageID string) (interface{}, error) {
+	return map[string]string{
+		"docker": "echo " + imageID,
+		"shell":  "echo " + imageID,
+	}, nil
+}
+
+// WaitCreated returns the stored container immediately.
+func (fc *FakeControl) WaitCreated(id string) (*schemas.Container, error) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	container, ok := fc.Containers[id]
+	if !ok {
+		return nil, fmt.Errorf("no container with id %s exists", id)
+	}
+	cont := *container
+
+	return &cont, nil
+}
+
+// Ping always succeeds.
+func (fc *FakeControl) Ping() error {
+	return nil
+}
+
+// FakeAgent is an in-process Agent that stores synced files in memory.
+type FakeAgent struct {
+	files map[string]map[string][]byte
+	mutex sync.Mutex
+}
+
+// NewFakeAgent creates a FakeAgent.
+func NewFakeAgent() *FakeAgent {
+	return &FakeAgent{files: make(map[string]map[string][]byte)}
+}
+
+// Files returns a copy of the files stored for a container.
+func (fa *FakeAgent) Files(id string) map[string][]byte {
+	fa.mutex.Lock()
+	defer fa.mutex.Unlock()
+
+	files := make(map[string][]byte)
+	for name, contents := range fa.files[id] {
+		files[name] = contents
+	}
+
+	return files
+}
+
+// Upload replaces a containers files with the contents of a .tar.gz.
+func (fa *FakeAgent) Upload(container *schemas.Container, contents io.Reader) error {
+	gzipReader, err := gzip.NewReader(contents)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	files := make(map[string][]byte)
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return err
+		}
+		files[path.Clean(header.Name)] = data
+	}
+
+	fa.mutex.Lock()
+	fa.files[container.ID] = files
+	fa.mutex.Unlock()
+	return nil
+}
+
+// Update creates, updates, or deletes a single file.
+func (fa *FakeAgent) Update(container *schemas.Container, full, name, status string) error {
+	name = fakeName(name)
+	if status == delancey.DeleteStatus {
+		fa.remove(container.ID, name)
+		return nil
+	}
+
+	info, err := os.Stat(full)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(full)
+	if err != nil {
+		return err
+	}
+
+	fa.store(container.ID, name, data)
+	return nil
+}
+
+// BatchUpdate stores each path, sending errors for the paths that failed.
+func (fa *FakeAgent) BatchUpdate(container *schemas.Container, paths map[string]string, errChan chan error) error {
+	defer close(errChan)
+
+	for full, name := range paths {
+		info, err := os.Stat(full)
+		if err == nil && info.IsDir() {
+			continue
+		}
+
+		var data []byte
+		if err == nil {
+			data, err = ioutil.ReadFile(full)
+		}
+		if err != nil {
+			errChan <- &delancey.BatchError{Path: full, Err: err}
+			continue
+		}
+
+		fa.store(container.ID, fakeName(name), data)
+	}
+
+	return nil
+}
+
+// UploadSSH does nothing.
+func (fa *FakeAgent) UploadSSH(container *schemas.Container, dir string) error {
+	return nil
+}
+
+// Ping always succeeds.
+func (fa *FakeAgent) Ping(container *schemas.Container) error {
+	return nil
+}
+
+// store sets the contents of a file for a container.
+func (fa *FakeAgent) store(id, name string, data []byte) {
+	fa.mutex.Lock()
+	defer fa.mutex.Unlock()
+
+	files, ok := fa.files[id]
+	if !ok {
+		files = make(map[string][]byte)
+		fa.files[id] = files
+	}
+	files[name] = data
+}
+
+// remove deletes a file, or a directory and its children for a container.
+func (fa *FakeAgent) remove(id, name string) {
+	fa.mutex.Lock()
+	defer fa.mutex.Unlock()
+
+	for file := range fa.files[id] {
+		if file == name || strings.HasPrefix(file, name+"/") {
+			delete(fa.files[id], file)
+		}
+	}
+}
+
+// fakeName converts a relative path to the slash separated form used by tar.
+func fakeName(name string) string {
+	return path.Clean(strings.Replace(name, string(os.PathSeparator), "/", -1))
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/Bowery/gopackages/schemas"
+	"github.com/pkg/sftp"
+)
+
+// FileEntry describes a file in a container.
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// newFileEntry creates a FileEntry for a file in dir.
+func newFileEntry(dir string, info os.FileInfo) *FileEntry {
+	return &FileEntry{
+		Name:    info.Name(),
+		Path:    path.Join(dir, info.Name()),
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
+
+// openSFTP starts an sftp session on the containers ssh connection.
+func openSFTP(container *schemas.Container) (*sftp.Client, error) {
+	client, err := containerManager.SSH.Get(container)
+	if err != nil {
+		return nil, err
+	}
+
+	return sftp.NewClient(client)
+}
+
+// listRemote lists the directory at dir in a container, directories
+// are listed before files.
+func listRemote(client *sftp.Client, dir string) ([]*FileEntry, error) {
+	infos, err := client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*FileEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, newFileEntry(dir, info))
+	}
+
+	sort.Sort(fileEntries(entries))
+	return entries, nil
+}
+
+// uploadRemote writes the contents to path in a container, creating any
+// missing parent directories.
+func uploadRemote(client *sftp.Client, dest string, contents io.Reader) (int64, error) {
+	err := client.MkdirAll(path.Dir(dest))
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := client.Create(dest)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return io.Copy(file, contents)
+}
+
+// fileEntries sorts entries with directories first, then by name.
+type fileEntries []*FileEntry
+
+func (fe fileEntries) Len() int {
+	return len(fe)
+}
+
+func (fe fileEntries) Swap(i, j int) {
+	fe[i], fe[j] = fe[j], fe[i]
+}
+
+func (fe fileEntries) Less(i, j int) bool {
+	if fe[i].IsDir != fe[j].IsDir {
+		return fe[i].IsDir
+	}
+
+	return fe[i].Name < fe[j].Name
+}
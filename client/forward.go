@@ -0,0 +1,212 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Bowery/desktop/logger"
+	"github.com/Bowery/gopackages/schemas"
+)
+
+// Forward forwards a local port to a port in a container.
+type Forward struct {
+	ContainerID string `json:"containerID"`
+	LocalPort   int    `json:"localPort"`
+	RemotePort  int    `json:"remotePort"`
+	container   *schemas.Container
+	listener    net.Listener
+	closed      bool
+}
+
+var forwardLog = logger.New("forward")
+
+// ForwardManager manages the local port forwards for all containers.
+type ForwardManager struct {
+	pool     *SSHPool
+	forwards map[string][]*Forward
+	mutex    sync.Mutex
+}
+
+// NewForwardManager creates a ForwardManager using the connections
+// in the given pool.
+func NewForwardManager(pool *SSHPool) *ForwardManager {
+	return &ForwardManager{
+		pool:     pool,
+		forwards: make(map[string][]*Forward),
+	}
+}
+
+// Add starts forwarding localhost:local to port remote in the container.
+func (fm *ForwardManager) Add(container *schemas.Container, local, remote int) (*Forward, error) {
+	if container.Address == "" {
+		return nil, fmt.Errorf("container %s isn't running yet", container.ID)
+	}
+	if local < 1 || local > 65535 || remote < 1 || remote > 65535 {
+		return nil, fmt.Errorf("ports must be between 1 and 65535")
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(local)))
+	if err != nil {
+		return nil, err
+	}
+
+	forward := &Forward{
+		ContainerID: container.ID,
+		LocalPort:   local,
+		RemotePort:  remote,
+		container:   container,
+		listener:    listener,
+	}
+
+	fm.mutex.Lock()
+	fm.forwards[container.ID] = append(fm.forwards[container.ID], forward)
+	fm.mutex.Unlock()
+
+	go fm.serve(forward)
+	return forward, nil
+}
+
+// List returns the forwards for a container.
+func (fm *ForwardManager) List(id string) []*Forward {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	forwards := make([]*Forward, len(fm.forwards[id]))
+	copy(forwards, fm.forwards[id])
+	return forwards
+}
+
+// Remove stops the forward on the local port for a container.
+func (fm *ForwardManager) Remove(id string, local int) error {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	for i, forward := range fm.forwards[id] {
+		if forward.LocalPort == local {
+			fm.forwards[id] = append(fm.forwards[id][:i], fm.forwards[id][i+1:]...)
+			forward.closed = true
+			return forward.listener.Close()
+		}
+	}
+
+	return fmt.Errorf("no forward on port %d exists for container %s", local, id)
+}
+
+// RemoveAll stops all the forwards for a container.
+func (fm *ForwardManager) RemoveAll(id string) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	for _, forward := range fm.forwards[id] {
+		forward.closed = true
+		forward.listener.Close()
+	}
+	delete(fm.forwards, id)
+}
+
+// Close stops all forwards.
+func (fm *ForwardManager) Close() error {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	for id, forwards := range fm.forwards {
+		for _, forward := range forwards {
+			forward.closed = true
+			forward.listener.Close()
+		}
+		delete(fm.forwards, id)
+	}
+
+	return nil
+}
+
+// serve accepts local connections until the forward is closed. If the
+// listener fails the forward is removed.
+func (fm *ForwardManager) serve(forward *Forward) {
+	for {
+		conn, err := forward.listener.Accept()
+		if err != nil {
+			netErr, ok := err.(net.Error)
+			if ok && netErr.Temporary() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+
+			fm.fail(forward, err)
+			return
+		}
+
+		go fm.handle(forward, conn)
+	}
+}
+
+// fail removes a forward whose listener stopped, unless it was closed.
+func (fm *ForwardManager) fail(forward *Forward, err error) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	if forward.closed {
+		return
+	}
+	forwardLog.Error("Forward stopped", "container", forward.ContainerID, "port", forward.LocalPort, "error", err)
+
+	forward.closed = true
+	forward.listener.Close()
+	forwards := fm.forwards[forward.ContainerID]
+	for i, f := range forwards {
+		if f == forward {
+			fm.forwards[forward.ContainerID] = append(forwards[:i], forwards[i+1:]...)
+			break
+		}
+	}
+}
+
+// handle proxies a local connection to the remote port over ssh.
+func (fm *ForwardManager) handle(forward *Forward, conn net.Conn) {
+	defer conn.Close()
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(forward.RemotePort))
+
+	client, err := fm.pool.Get(forward.container)
+	if err != nil {
+		forwardLog.Warn("Forward failed", "container", forward.ContainerID, "port", forward.RemotePort, "error", err)
+		return
+	}
+
+	remote, err := client.Dial("tcp", addr)
+	if err != nil {
+		// The connection may have gone stale, redial once.
+		fm.pool.Reset(forward.ContainerID)
+		client, err = fm.pool.Get(forward.container)
+		if err == nil {
+			remote, err = client.Dial("tcp", addr)
+		}
+		if err != nil {
+			forwardLog.Warn("Forward failed", "container", forward.ContainerID, "port", forward.RemotePort, "error", err)
+			return
+		}
+	}
+	defer remote.Close()
+
+	pipe(conn, remote)
+}
+
+// pipe copies between two connections until either side closes.
+func pipe(a, b io.ReadWriter) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+
+	<-done
+}
@@ -0,0 +1,130 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is the number of events buffered for each subscriber.
+const subscriberBuffer = 256
+
+// Subscriber receives the events matching its filter. If it falls behind
+// and its buffer fills, events are dropped and Lagged is signaled so it
+// can catch up from the event log.
+type Subscriber struct {
+	Filter *EventFilter
+	Events chan *StreamEvent
+	Lagged chan struct{}
+	// LastID is the id of the latest event when it subscribed.
+	LastID uint64
+}
+
+// EventHub sends published events to its subscribers without blocking on
+// slow ones, and keeps them in a log for replay.
+type EventHub struct {
+	Log         *EventLog
+	subscribers map[*Subscriber]bool
+	mutex       sync.Mutex
+}
+
+// NewEventHub creates a hub keeping size events for replay.
+func NewEventHub(size int) *EventHub {
+	return &EventHub{
+		Log:         NewEventLog(size),
+		subscribers: make(map[*Subscriber]bool),
+	}
+}
+
+// Publish adds an event to the log and sends it to the subscribers.
+func (hub *EventHub) Publish(typ, containerID string, data interface{}) *StreamEvent {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	ev := hub.Log.Add(typ, containerID, data)
+	for sub := range hub.subscribers {
+		if !sub.Filter.Match(ev) {
+			continue
+		}
+
+		select {
+		case sub.Events <- ev:
+		default:
+			// Drop the event, the subscriber replays what it missed from the log.
+			select {
+			case sub.Lagged <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	return ev
+}
+
+// Subscribe adds a subscriber for the events matching the filter.
+func (hub *EventHub) Subscribe(filter *EventFilter) *Subscriber {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	sub := &Subscriber{
+		Filter: filter,
+		Events: make(chan *StreamEvent, subscriberBuffer),
+		Lagged: make(chan struct{}, 1),
+		LastID: hub.Log.LastID(),
+	}
+	hub.subscribers[sub] = true
+
+	return sub
+}
+
+// Unsubscribe removes a subscriber.
+func (hub *EventHub) Unsubscribe(sub *Subscriber) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	delete(hub.subscribers, sub)
+}
+
+// Len returns the number of subscribers.
+func (hub *EventHub) Len() int {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	return len(hub.subscribers)
+}
+
+// Replay sends the logged events after id that match the subscribers
+// filter, returning the id of the latest event. If some have been dropped
+// from the log, or the id is from before a restart, a reset event is sent
+// and every logged event is replayed.
+func (hub *EventHub) Replay(sub *Subscriber, id uint64, send func(*StreamEvent) error) (uint64, error) {
+	events, complete := hub.Log.Since(id)
+	sent := id
+	if !complete {
+		sent = sub.LastID
+		if len(events) > 0 {
+			sent = events[0].ID - 1
+		}
+
+		reset := &StreamEvent{ID: sent, Type: eventReset, Time: time.Now(), Data: map[string]uint64{"lastEventID": id}}
+		err := send(reset)
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	for _, ev := range events {
+		if sub.Filter.Match(ev) {
+			err := send(ev)
+			if err != nil {
+				return sent, err
+			}
+		}
+
+		if ev.ID > sent {
+			sent = ev.ID
+		}
+	}
+
+	return sent, nil
+}
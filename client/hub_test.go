@@ -0,0 +1,198 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"testing"
+)
+
+// collect returns a send func that appends to events.
+func collect(events *[]*StreamEvent) func(*StreamEvent) error {
+	return func(ev *StreamEvent) error {
+		*events = append(*events, ev)
+		return nil
+	}
+}
+
+func TestHubSlowSubscriberLagged(t *testing.T) {
+	hub := NewEventHub(eventBufferSize)
+	sub := hub.Subscribe(&EventFilter{})
+	defer hub.Unsubscribe(sub)
+
+	for i := 0; i < subscriberBuffer; i++ {
+		hub.Publish(eventSync, "", nil)
+	}
+	select {
+	case <-sub.Lagged:
+		t.Fatal("Subscriber lagged before its buffer filled")
+	default:
+	}
+
+	hub.Publish(eventSync, "", nil)
+	select {
+	case <-sub.Lagged:
+	default:
+		t.Fatal("Expected subscriber to lag once its buffer filled")
+	}
+	if len(sub.Events) != subscriberBuffer {
+		t.Errorf("Expected %d buffered events, got %d", subscriberBuffer, len(sub.Events))
+	}
+}
+
+func TestHubReplayAfterLag(t *testing.T) {
+	hub := NewEventHub(eventBufferSize)
+	sub := hub.Subscribe(&EventFilter{})
+	defer hub.Unsubscribe(sub)
+
+	total := subscriberBuffer + 10
+	for i := 0; i < total; i++ {
+		hub.Publish(eventSync, "", nil)
+	}
+	<-sub.Lagged
+
+	received := make([]*StreamEvent, 0)
+	sent := sub.LastID
+	for len(sub.Events) > 0 {
+		ev := <-sub.Events
+		received = append(received, ev)
+		sent = ev.ID
+	}
+
+	sent, err := hub.Replay(sub, sent, collect(&received))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != uint64(total) {
+		t.Errorf("Expected sent to be %d, got %d", total, sent)
+	}
+	if len(received) != total {
+		t.Fatalf("Expected %d events, got %d", total, len(received))
+	}
+	for i, ev := range received {
+		if ev.ID != uint64(i+1) {
+			t.Fatalf("Expected event %d to have id %d, got %d", i, i+1, ev.ID)
+		}
+	}
+}
+
+func TestHubReplayDropped(t *testing.T) {
+	hub := NewEventHub(4)
+	for i := 0; i < 10; i++ {
+		hub.Publish(eventSync, "", nil)
+	}
+	sub := hub.Subscribe(&EventFilter{})
+	defer hub.Unsubscribe(sub)
+
+	events := make([]*StreamEvent, 0)
+	sent, err := hub.Replay(sub, 2, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != 10 {
+		t.Errorf("Expected sent to be 10, got %d", sent)
+	}
+	if len(events) != 5 || events[0].Type != eventReset {
+		t.Fatalf("Expected a reset and 4 events, got %d events", len(events))
+	}
+	if events[0].ID != 6 {
+		t.Errorf("Expected reset to have id 6, got %d", events[0].ID)
+	}
+	for i, ev := range events[1:] {
+		if ev.ID != uint64(i+7) {
+			t.Errorf("Expected event %d to have id %d, got %d", i, i+7, ev.ID)
+		}
+	}
+}
+
+func TestHubReplayStaleID(t *testing.T) {
+	hub := NewEventHub(eventBufferSize)
+	for i := 0; i < 3; i++ {
+		hub.Publish(eventSync, "", nil)
+	}
+	sub := hub.Subscribe(&EventFilter{})
+	defer hub.Unsubscribe(sub)
+
+	_, complete := hub.Log.Since(100)
+	if complete {
+		t.Error("Expected an id ahead of the log to be incomplete")
+	}
+
+	events := make([]*StreamEvent, 0)
+	sent, err := hub.Replay(sub, 100, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != 3 {
+		t.Errorf("Expected sent to be 3, got %d", sent)
+	}
+	if len(events) != 4 || events[0].Type != eventReset {
+		t.Fatalf("Expected a reset and 3 events, got %d events", len(events))
+	}
+	for i, ev := range events[1:] {
+		if ev.ID != uint64(i+1) {
+			t.Errorf("Expected event %d to have id %d, got %d", i, i+1, ev.ID)
+		}
+	}
+}
+
+func TestHubReplayStaleIDEmptyLog(t *testing.T) {
+	hub := NewEventHub(eventBufferSize)
+	sub := hub.Subscribe(&EventFilter{})
+	defer hub.Unsubscribe(sub)
+
+	events := make([]*StreamEvent, 0)
+	sent, err := hub.Replay(sub, 5, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != 0 {
+		t.Errorf("Expected sent to be 0, got %d", sent)
+	}
+	if len(events) != 1 || events[0].Type != eventReset {
+		t.Fatalf("Expected only a reset, got %d events", len(events))
+	}
+}
+
+func TestHubReplayUpToDate(t *testing.T) {
+	hub := NewEventHub(eventBufferSize)
+	for i := 0; i < 3; i++ {
+		hub.Publish(eventSync, "", nil)
+	}
+	sub := hub.Subscribe(&EventFilter{})
+	defer hub.Unsubscribe(sub)
+
+	events := make([]*StreamEvent, 0)
+	sent, err := hub.Replay(sub, 3, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != 3 || len(events) != 0 {
+		t.Errorf("Expected nothing replayed, got %d events and sent %d", len(events), sent)
+	}
+}
+
+func TestHubReplayFilter(t *testing.T) {
+	hub := NewEventHub(eventBufferSize)
+	hub.Publish(eventSync, "a", nil)
+	hub.Publish(eventSync, "b", nil)
+	hub.Publish(eventSync, "a", nil)
+	hub.Publish(eventSync, "b", nil)
+	sub := hub.Subscribe(&EventFilter{Containers: map[string]bool{"a": true}})
+	defer hub.Unsubscribe(sub)
+
+	events := make([]*StreamEvent, 0)
+	sent, err := hub.Replay(sub, 0, collect(&events))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != 4 {
+		t.Errorf("Expected sent to be 4, got %d", sent)
+	}
+	if len(events) != 2 || events[0].ID != 1 || events[1].ID != 3 {
+		t.Errorf("Expected events 1 and 3, got %d events", len(events))
+	}
+}
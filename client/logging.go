@@ -0,0 +1,64 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Bowery/desktop/logger"
+	"github.com/Bowery/gopackages/sys"
+)
+
+// Loggers for the client and file syncing, the other subsystems have
+// their own in their files.
+var (
+	clientLog = logger.New("client")
+	syncLog   = logger.New("sync")
+)
+
+// Limits for the log file before it's rotated.
+const (
+	logMaxSize    = 10 * 1024 * 1024
+	logMaxBackups = 5
+)
+
+// defaultLogPath is the log file used if none is given.
+func defaultLogPath() string {
+	return filepath.Join(os.Getenv(sys.HomeVar), ".bowery", "logs", "client.log")
+}
+
+// setupLogging sets up the loggers to write to the rotated log file at
+// path, and to stderr if running as a daemon or path is empty. The
+// standard logger is captured so dependencies log the same way. Systemd
+// adds its own timestamps so they're left out when only logging to it.
+func setupLogging(path, level, format string, daemon bool) error {
+	lvl, err := logger.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if format != logger.LogfmtFormat && format != logger.JSONFormat {
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	opts := logger.Options{Level: lvl, Format: format, Writers: make([]io.Writer, 0, 2)}
+	if path != "" {
+		file, err := logger.OpenRotatingFile(path, logMaxSize, logMaxBackups)
+		if err != nil {
+			return err
+		}
+
+		opts.Writers = append(opts.Writers, file)
+	}
+	if daemon || path == "" {
+		opts.Writers = append(opts.Writers, os.Stderr)
+		opts.NoTime = path == "" && os.Getenv("JOURNAL_STREAM") != ""
+	}
+
+	logger.Setup(opts)
+	log.SetFlags(0)
+	log.SetOutput(logger.New("std").Writer(logger.InfoLevel))
+	return nil
+}
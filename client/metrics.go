@@ -0,0 +1,360 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Bowery/gopackages/sys"
+)
+
+// Buckets for the metric histograms.
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	sizeBuckets     = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000}
+)
+
+// Metrics for syncing, event streams, terminals and updates.
+var (
+	syncWalkDuration = &Histogram{
+		Name:    "bowery_sync_walk_duration_seconds",
+		Help:    "Time spent walking the local path on each sync pass.",
+		Buckets: durationBuckets,
+	}
+	syncFiles = &Counter{
+		Name:  "bowery_sync_files_total",
+		Help:  "Files sent to agents by change status.",
+		Label: "status",
+	}
+	syncBytes = &Counter{
+		Name:  "bowery_sync_bytes_total",
+		Help:  "Bytes sent to agents by kind of upload.",
+		Label: "kind",
+	}
+	syncBatchSize = &Histogram{
+		Name:    "bowery_sync_batch_size_files",
+		Help:    "Number of files in each batch update.",
+		Buckets: sizeBuckets,
+	}
+	syncRetries = &Counter{
+		Name: "bowery_sync_retries_total",
+		Help: "Uploads retried after failing.",
+	}
+	syncUploadDuration = &Histogram{
+		Name:    "bowery_sync_upload_duration_seconds",
+		Help:    "Latency of requests to agents by kind of upload.",
+		Label:   "kind",
+		Buckets: durationBuckets,
+	}
+	updateChecks = &Counter{
+		Name:  "bowery_client_update_checks_total",
+		Help:  "Update checks requested through the client api by result.",
+		Label: "result",
+	}
+)
+
+// Kinds of uploads for the sync metrics.
+const (
+	uploadFull   = "full"
+	uploadFile   = "file"
+	uploadBatch  = "batch"
+	uploadDelete = "delete"
+)
+
+// metrics are written in the order given for /_/metrics.
+var metrics = []Metric{
+	syncWalkDuration,
+	syncFiles,
+	syncBytes,
+	syncBatchSize,
+	syncRetries,
+	syncUploadDuration,
+	&GaugeFunc{
+		Name: "bowery_event_subscribers",
+		Help: "Connected event stream and socket subscribers.",
+		Value: func() float64 {
+			if eventHub == nil {
+				return 0
+			}
+			return float64(eventHub.Len())
+		},
+	},
+	&GaugeFunc{
+		Name: "bowery_terminals_active",
+		Help: "Live terminal sessions.",
+		Value: func() float64 {
+			if containerManager == nil {
+				return 0
+			}
+			return float64(containerManager.Terminals.Len())
+		},
+	},
+	updateChecks,
+	&CounterFunc{
+		Name:   "bowery_updater_update_checks_total",
+		Help:   "Update checks made by the updater by result.",
+		Label:  "result",
+		Values: updaterChecks,
+	},
+}
+
+// updaterChecks reads the check results saved by the updater, nothing is
+// returned if it hasn't checked yet.
+func updaterChecks() map[string]float64 {
+	values := make(map[string]float64)
+	data, err := ioutil.ReadFile(filepath.Join(os.Getenv(sys.HomeVar), ".bowery", "updater_checks.json"))
+	if err != nil {
+		return values
+	}
+
+	var checks struct {
+		Results map[string]float64 `json:"results"`
+	}
+	err = json.Unmarshal(data, &checks)
+	if err != nil {
+		clientLog.Warn("Reading updater checks failed", "error", err)
+		return values
+	}
+
+	for result, n := range checks.Results {
+		values[result] = n
+	}
+	return values
+}
+
+// Metric is written in the Prometheus text format.
+type Metric interface {
+	WriteTo(w io.Writer) (int64, error)
+}
+
+// WriteMetrics writes all the metrics to w.
+func WriteMetrics(w io.Writer) error {
+	for _, metric := range metrics {
+		_, err := metric.WriteTo(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Counter is a value that only goes up, optionally split by a label.
+type Counter struct {
+	Name   string
+	Help   string
+	Label  string
+	values map[string]float64
+	mutex  sync.Mutex
+}
+
+// Add adds n to the counter for the label value.
+func (counter *Counter) Add(label string, n float64) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	if counter.values == nil {
+		counter.values = make(map[string]float64)
+	}
+	counter.values[label] += n
+}
+
+// Inc adds one to the counter for the label value.
+func (counter *Counter) Inc(label string) {
+	counter.Add(label, 1)
+}
+
+// WriteTo writes the counter to w.
+func (counter *Counter) WriteTo(w io.Writer) (int64, error) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	mw := &metricWriter{w: w}
+	mw.header(counter.Name, counter.Help, "counter")
+	if counter.Label == "" {
+		mw.sample(counter.Name, "", counter.values[""])
+		return mw.n, mw.err
+	}
+
+	for _, label := range sortedKeys(counter.values) {
+		mw.sample(counter.Name, labelPair(counter.Label, label), counter.values[label])
+	}
+	return mw.n, mw.err
+}
+
+// CounterFunc is a counter split by a label whose values are read when the
+// metrics are written.
+type CounterFunc struct {
+	Name   string
+	Help   string
+	Label  string
+	Values func() map[string]float64
+}
+
+// WriteTo writes the counter to w.
+func (counter *CounterFunc) WriteTo(w io.Writer) (int64, error) {
+	values := counter.Values()
+
+	mw := &metricWriter{w: w}
+	mw.header(counter.Name, counter.Help, "counter")
+	for _, label := range sortedKeys(values) {
+		mw.sample(counter.Name, labelPair(counter.Label, label), values[label])
+	}
+	return mw.n, mw.err
+}
+
+// GaugeFunc is a value read when the metrics are written.
+type GaugeFunc struct {
+	Name  string
+	Help  string
+	Value func() float64
+}
+
+// WriteTo writes the gauge to w.
+func (gauge *GaugeFunc) WriteTo(w io.Writer) (int64, error) {
+	mw := &metricWriter{w: w}
+	mw.header(gauge.Name, gauge.Help, "gauge")
+	mw.sample(gauge.Name, "", gauge.Value())
+	return mw.n, mw.err
+}
+
+// Histogram counts observations in buckets, optionally split by a label.
+type Histogram struct {
+	Name    string
+	Help    string
+	Label   string
+	Buckets []float64
+	series  map[string]*histogramSeries
+	mutex   sync.Mutex
+}
+
+// histogramSeries is the observations for a single label value.
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds a value for the label value.
+func (hist *Histogram) Observe(label string, val float64) {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+
+	if hist.series == nil {
+		hist.series = make(map[string]*histogramSeries)
+	}
+	series, ok := hist.series[label]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(hist.Buckets))}
+		hist.series[label] = series
+	}
+
+	for i, bound := range hist.Buckets {
+		if val <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += val
+}
+
+// ObserveSince adds the seconds since start for the label value.
+func (hist *Histogram) ObserveSince(label string, start time.Time) {
+	hist.Observe(label, time.Since(start).Seconds())
+}
+
+// WriteTo writes the histogram to w.
+func (hist *Histogram) WriteTo(w io.Writer) (int64, error) {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+
+	mw := &metricWriter{w: w}
+	mw.header(hist.Name, hist.Help, "histogram")
+
+	labels := make([]string, 0, len(hist.series))
+	for label := range hist.series {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		series := hist.series[label]
+		pair := ""
+		if hist.Label != "" {
+			pair = labelPair(hist.Label, label) + ","
+		}
+
+		for i, bound := range hist.Buckets {
+			mw.sample(hist.Name+"_bucket", pair+labelPair("le", formatFloat(bound)), float64(series.counts[i]))
+		}
+		mw.sample(hist.Name+"_bucket", pair+labelPair("le", "+Inf"), float64(series.count))
+		mw.sample(hist.Name+"_sum", strings.TrimSuffix(pair, ","), series.sum)
+		mw.sample(hist.Name+"_count", strings.TrimSuffix(pair, ","), float64(series.count))
+	}
+
+	return mw.n, mw.err
+}
+
+// metricWriter writes lines in the text format, keeping the first error.
+type metricWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (mw *metricWriter) printf(format string, args ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(mw.w, format, args...)
+	mw.n += int64(n)
+	mw.err = err
+}
+
+func (mw *metricWriter) header(name, help, kind string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (mw *metricWriter) sample(name, labels string, val float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+
+	mw.printf("%s %s\n", name, formatFloat(val))
+}
+
+// labelPair formats a label name and its quoted value.
+func labelPair(name, val string) string {
+	return name + "=" + strconv.Quote(val)
+}
+
+// formatFloat formats a sample value.
+func formatFloat(val float64) string {
+	if math.IsInf(val, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a counters values in order.
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
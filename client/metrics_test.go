@@ -0,0 +1,53 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Bowery/gopackages/sys"
+)
+
+func TestUpdaterChecksMetric(t *testing.T) {
+	home, err := ioutil.TempDir("", "bowery-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv(sys.HomeVar, os.Getenv(sys.HomeVar))
+	os.Setenv(sys.HomeVar, home)
+
+	counter := &CounterFunc{Name: "checks_total", Help: "Checks.", Label: "result", Values: updaterChecks}
+	var buf bytes.Buffer
+	_, err = counter.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "checks_total{") {
+		t.Error("Expected no samples before the updater checks, got", buf.String())
+	}
+
+	err = os.MkdirAll(filepath.Join(home, ".bowery"), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(home, ".bowery", "updater_checks.json"),
+			[]byte(`{"results":{"noupdate":3,"error":1},"lastCheck":"2015-01-01T00:00:00Z"}`), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	_, err = counter.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []string{`checks_total{result="error"} 1`, `checks_total{result="noupdate"} 3`} {
+		if !strings.Contains(buf.String(), sample) {
+			t.Errorf("Expected %s in %s", sample, buf.String())
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Bowery/gopackages/schemas"
+	"github.com/Bowery/gopackages/sys"
+)
+
+// PrivacySettings control which identity fields are sent to kenmare as
+// the collaborator for containers.
+type PrivacySettings struct {
+	SendName    bool `json:"sendName"`
+	SendEmail   bool `json:"sendEmail"`
+	SendMACAddr bool `json:"sendMACAddr"`
+	// Name and Email are sent instead of the ones from git if set.
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Validate checks the identity overrides.
+func (settings *PrivacySettings) Validate() error {
+	if settings.Email != "" && !strings.Contains(settings.Email, "@") {
+		return errors.New("email must be an email address")
+	}
+
+	return nil
+}
+
+// Privacy stores the privacy settings in a file only the user can read.
+type Privacy struct {
+	Path     string
+	settings PrivacySettings
+	mutex    sync.RWMutex
+	id       string
+	idOnce   sync.Once
+}
+
+// privacyPath is the default privacy settings file.
+func privacyPath() string {
+	return filepath.Join(os.Getenv(sys.HomeVar), ".bowery", "privacy.json")
+}
+
+// LoadPrivacy reads the privacy settings at path, everything is sent if
+// the file doesn't exist.
+func LoadPrivacy(path string) (*Privacy, error) {
+	privacy := &Privacy{
+		Path:     path,
+		settings: PrivacySettings{SendName: true, SendEmail: true, SendMACAddr: true},
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return privacy, nil
+		}
+
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &privacy.settings)
+	if err == nil {
+		err = privacy.settings.Validate()
+	}
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+
+	return privacy, nil
+}
+
+// Get returns the current settings.
+func (privacy *Privacy) Get() PrivacySettings {
+	privacy.mutex.RLock()
+	defer privacy.mutex.RUnlock()
+
+	return privacy.settings
+}
+
+// Set validates and saves the settings.
+func (privacy *Privacy) Set(settings PrivacySettings) error {
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	privacy.mutex.Lock()
+	defer privacy.mutex.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(privacy.Path), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(privacy.Path, contents, 0600)
+	}
+	if err != nil {
+		return err
+	}
+
+	privacy.settings = settings
+	return nil
+}
+
+// MACAddr returns the MAC address if it's allowed to be sent, otherwise
+// the anonymous install id.
+func (privacy *Privacy) MACAddr() string {
+	if !privacy.Get().SendMACAddr {
+		return privacy.installID()
+	}
+
+	addr, _ := sys.GetMACAddress()
+	return addr
+}
+
+// installID returns a random id stored next to the settings, so kenmare
+// can tell installs apart without the MAC address.
+func (privacy *Privacy) installID() string {
+	privacy.idOnce.Do(func() {
+		path := filepath.Join(filepath.Dir(privacy.Path), "install_id")
+		contents, err := ioutil.ReadFile(path)
+		if err == nil && len(bytes.TrimSpace(contents)) > 0 {
+			privacy.id = string(bytes.TrimSpace(contents))
+			return
+		}
+
+		id := make([]byte, 16)
+		rand.Read(id)
+		privacy.id = hex.EncodeToString(id)
+
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(privacy.id+"\n"), 0600)
+		}
+		if err != nil {
+			clientLog.Warn("Saving install id failed", "path", path, "error", err)
+		}
+	})
+
+	return privacy.id
+}
+
+// Collaborator gets the allowed identity fields, using the overrides or
+// git config for the name and email. The install id is sent in place of
+// the MAC address if it isn't allowed.
+func (privacy *Privacy) Collaborator() *schemas.Collaborator {
+	settings := privacy.Get()
+	collaborator := new(schemas.Collaborator)
+	var wg sync.WaitGroup
+
+	// Get name, email, and MAC address in parallel.
+	if settings.SendName {
+		collaborator.Name = settings.Name
+		if collaborator.Name == "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				collaborator.Name = gitConfig("user.name")
+			}()
+		}
+	}
+
+	if settings.SendEmail {
+		collaborator.Email = settings.Email
+		if collaborator.Email == "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				collaborator.Email = gitConfig("user.email")
+			}()
+		}
+	}
+
+	if settings.SendMACAddr {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, _ := sys.GetMACAddress()
+			collaborator.MACAddr = addr
+		}()
+	} else {
+		collaborator.MACAddr = privacy.installID()
+	}
+
+	wg.Wait()
+	return collaborator
+}
+
+// gitConfig gets a value from the users git config.
+func gitConfig(key string) string {
+	cmd := sys.NewCommand("git config "+key, nil)
+	out, _ := cmd.Output()
+
+	return strings.Replace(string(out), "\n", "", -1)
+}
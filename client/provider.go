@@ -0,0 +1,158 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Bowery/delancey/delancey"
+	"github.com/Bowery/gopackages/config"
+	"github.com/Bowery/gopackages/schemas"
+	"github.com/Bowery/kenmare/kenmare"
+	"github.com/Bowery/pusher"
+)
+
+// dialCheckTimeout is how long reachability checks wait to connect.
+const dialCheckTimeout = 5 * time.Second
+
+// Control is the control plane that manages projects and containers.
+type Control interface {
+	GetProject(id string) (*schemas.Project, error)
+	UpdateProject(addr string, project *schemas.Project) error
+	CreateContainer(imageID, localPath, dockerfile string) (*schemas.Container, error)
+	UpdateCollaborator(imageID string, collaborator *schemas.Collaborator) error
+	DeleteContainer(id string) error
+	SaveContainer(id, addr string) error
+	Export(imageID string) (interface{}, error)
+
+	// WaitCreated blocks until the container is running and returns
+	// the container with its remote details filled in.
+	WaitCreated(id string) (*schemas.Container, error)
+
+	// Ping checks the control plane can be reached.
+	Ping() error
+}
+
+// Agent is the data plane that syncs files to the agent in a container.
+type Agent interface {
+	Upload(container *schemas.Container, contents io.Reader) error
+	Update(container *schemas.Container, full, name, status string) error
+	BatchUpdate(container *schemas.Container, paths map[string]string, errChan chan error) error
+	UploadSSH(container *schemas.Container, dir string) error
+
+	// Ping checks the agent in a container can be reached.
+	Ping(container *schemas.Container) error
+}
+
+// kenmareControl implements Control using kenmare and Pusher.
+type kenmareControl struct{}
+
+func (kc *kenmareControl) GetProject(id string) (*schemas.Project, error) {
+	return kenmare.GetProject(id)
+}
+
+func (kc *kenmareControl) UpdateProject(addr string, project *schemas.Project) error {
+	return kenmare.UpdateProject(addr, project)
+}
+
+func (kc *kenmareControl) CreateContainer(imageID, localPath, dockerfile string) (*schemas.Container, error) {
+	return kenmare.CreateContainer(imageID, localPath, dockerfile)
+}
+
+func (kc *kenmareControl) UpdateCollaborator(imageID string, collaborator *schemas.Collaborator) error {
+	_, err := kenmare.UpdateCollaborator(imageID, collaborator)
+	return err
+}
+
+func (kc *kenmareControl) DeleteContainer(id string) error {
+	return kenmare.DeleteContainer(id)
+}
+
+func (kc *kenmareControl) SaveContainer(id, addr string) error {
+	return kenmare.SaveContainer(id, addr)
+}
+
+func (kc *kenmareControl) Export(imageID string) (interface{}, error) {
+	return kenmare.Export(imageID)
+}
+
+func (kc *kenmareControl) WaitCreated(id string) (*schemas.Container, error) {
+	conn, err := pusher.New(config.PusherKey)
+	if err != nil {
+		return nil, err
+	}
+	channel := conn.Channel("container-" + id)
+	ev := channel.Bind("created")
+	data := (<-ev).(string)
+	conn.Disconnect()
+
+	container := new(schemas.Container)
+	err = json.Unmarshal([]byte(data), container)
+	if err != nil {
+		return nil, err
+	}
+
+	return container, nil
+}
+
+func (kc *kenmareControl) Ping() error {
+	addr := config.KenmareAddr
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	// Any response short of a server error means kenmare is up.
+	client := &http.Client{Timeout: dialCheckTimeout}
+	res, err := client.Get(addr)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("kenmare responded with %s", res.Status)
+	}
+	return nil
+}
+
+// delanceyAgent implements Agent using the delancey agent api.
+type delanceyAgent struct{}
+
+func (da *delanceyAgent) Upload(container *schemas.Container, contents io.Reader) error {
+	return delancey.Upload(container, contents)
+}
+
+func (da *delanceyAgent) Update(container *schemas.Container, full, name, status string) error {
+	return delancey.Update(container, full, name, status)
+}
+
+func (da *delanceyAgent) BatchUpdate(container *schemas.Container, paths map[string]string, errChan chan error) error {
+	return delancey.BatchUpdate(container, paths, errChan)
+}
+
+func (da *delanceyAgent) UploadSSH(container *schemas.Container, dir string) error {
+	return delancey.UploadSSH(container, dir)
+}
+
+func (da *delanceyAgent) Ping(container *schemas.Container) error {
+	if container.Address == "" {
+		return fmt.Errorf("container %s isn't running yet", container.ID)
+	}
+
+	return dialCheck(net.JoinHostPort(container.Address, config.DelanceyProdPort))
+}
+
+// dialCheck checks a tcp address accepts connections.
+func dialCheck(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, dialCheckTimeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
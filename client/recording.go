@@ -0,0 +1,155 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordingExt is the extension used for asciicast recordings.
+const recordingExt = ".cast"
+
+// recordingUnsafeRe matches characters not allowed in recording names.
+var recordingUnsafeRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Recorder writes a terminal session to a file in asciicast v2 format.
+type Recorder struct {
+	file  *os.File
+	start time.Time
+	mutex sync.Mutex
+}
+
+// Recording describes a recording stored on disk.
+type Recording struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// NewRecorder creates a recording in dir for a terminal and writes the
+// asciicast header.
+func NewRecorder(dir string, term *Terminal, rows, cols int) (*Recorder, error) {
+	err := os.MkdirAll(dir, os.ModePerm|os.ModeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	name := fmt.Sprintf("%s-%s-%d%s", safeRecordingName(term.ContainerID),
+		safeRecordingName(term.ID), start.Unix(), recordingExt)
+	path, err := RecordingPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     cols,
+		"height":    rows,
+		"timestamp": start.Unix(),
+		"env":       map[string]string{"TERM": "xterm-256color"},
+	})
+	if err == nil {
+		_, err = fmt.Fprintf(file, "%s\n", header)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Recorder{file: file, start: start}, nil
+}
+
+// Output records output from the shell.
+func (rec *Recorder) Output(b []byte) error {
+	return rec.event("o", string(b))
+}
+
+// Resize records a change in the terminals size.
+func (rec *Recorder) Resize(rows, cols int) error {
+	return rec.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close closes the recording.
+func (rec *Recorder) Close() error {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	return rec.file.Close()
+}
+
+// event writes an event line with the time since the recording started.
+func (rec *Recorder) event(code, data string) error {
+	elapsed := time.Since(rec.start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, code, data})
+	if err != nil {
+		return err
+	}
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	_, err = fmt.Fprintf(rec.file, "%s\n", line)
+	return err
+}
+
+// ListRecordings returns the recordings stored in dir.
+func ListRecordings(dir string) ([]*Recording, error) {
+	recordings := make([]*Recording, 0)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return recordings, err
+	}
+
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), recordingExt) {
+			continue
+		}
+
+		recordings = append(recordings, &Recording{
+			Name:      info.Name(),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime(),
+		})
+	}
+
+	return recordings, nil
+}
+
+// RecordingPath returns the path to a recording, validating the name
+// doesn't leave dir.
+func RecordingPath(dir, name string) (string, error) {
+	if name != filepath.Base(name) || !strings.HasSuffix(name, recordingExt) {
+		return "", fmt.Errorf("invalid recording name %s", name)
+	}
+
+	dir = filepath.Clean(dir)
+	path := filepath.Clean(filepath.Join(dir, name))
+	if filepath.Dir(path) != dir {
+		return "", fmt.Errorf("invalid recording name %s", name)
+	}
+
+	return path, nil
+}
+
+// safeRecordingName replaces anything that isn't safe in a file name.
+func safeRecordingName(str string) string {
+	return recordingUnsafeRe.ReplaceAllString(str, "_")
+}
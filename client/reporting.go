@@ -0,0 +1,44 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"github.com/Bowery/desktop/reporter"
+	"github.com/Bowery/gopackages/config"
+)
+
+// setupErrorReporting creates the error reporter from the shared config,
+// backend overrides the configured backend if given. Nothing is reported
+// if the config is invalid.
+func setupErrorReporting(backend string) reporter.ErrorReporter {
+	conf, err := reporter.ReadConfig(reporter.ConfigPath())
+	if err == nil {
+		if backend != "" {
+			conf.Backend = backend
+		}
+		conf.Env = env
+		conf.Release = VERSION
+
+		var rep reporter.ErrorReporter
+		rep, err = reporter.New(conf, config.RollbarToken)
+		if err == nil {
+			clientLog.Debug("Error reporting enabled", "backend", conf.Backend)
+			return rep
+		}
+	}
+
+	clientLog.Warn("Error reporting disabled", "error", err)
+	return reporter.Nop{}
+}
+
+// reportError reports an error with the client version.
+func reportError(err error, custom map[string]string) {
+	if custom == nil {
+		custom = make(map[string]string)
+	}
+	custom["version"] = VERSION
+
+	repErr := errorReporter.Report(err, custom)
+	if repErr != nil {
+		clientLog.Debug("Reporting error failed", "error", repErr)
+	}
+}
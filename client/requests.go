@@ -7,45 +7,66 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/Bowery/desktop/logger"
 	"github.com/Bowery/gopackages/config"
 	"github.com/Bowery/gopackages/requests"
 	"github.com/Bowery/gopackages/schemas"
-	"github.com/Bowery/gopackages/ssh"
 	"github.com/Bowery/gopackages/sys"
 	"github.com/Bowery/gopackages/update"
-	"github.com/Bowery/gopackages/util"
-	"github.com/Bowery/gopackages/web"
-	"github.com/Bowery/kenmare/kenmare"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/unrolled/render"
+	"golang.org/x/crypto/ssh"
 )
 
-const boweryFileTmpl = `DO NOT DELETE THIS FILE. It is a key component of Bowery (http://bowery.io/start).
-For questions, email hello@bowery.io and include your id (%s) in the email.`
-
-var routes = []web.Route{
+var routes = []Route{
 	{"GET", "/projects/{id}", getProjectByIDHandler, false},
 	{"PUT", "/projects/{id}", updateProjectByIDHandler, false},
+	{"GET", "/containers", getContainersHandler, false},
 	{"POST", "/containers", createContainerHandler, false},
+	{"GET", "/containers/{id}", getContainerHandler, false},
 	{"DELETE", "/containers/{id}", deleteContainerHandler, false},
 	{"PUT", "/containers/{id}", updateContainerHandler, false},
+	{"GET", "/containers/{id}/forwards", getForwardsHandler, false},
+	{"POST", "/containers/{id}/forwards", createForwardHandler, false},
+	{"DELETE", "/containers/{id}/forwards", deleteForwardsHandler, false},
+	{"GET", "/containers/{id}/tunnels", getTunnelsHandler, false},
+	{"POST", "/containers/{id}/tunnels", createTunnelHandler, false},
+	{"DELETE", "/containers/{id}/tunnels", deleteTunnelsHandler, false},
+	{"POST", "/containers/{id}/exec", execHandler, false},
+	{"GET", "/containers/{id}/files", getFilesHandler, false},
+	{"GET", "/containers/{id}/files/download", downloadFileHandler, false},
+	{"POST", "/containers/{id}/files/upload", uploadFileHandler, false},
+	{"GET", "/containers/{id}/errors", getSyncErrorsHandler, false},
+	{"DELETE", "/containers/{id}/errors", clearSyncErrorsHandler, false},
+	{"GET", "/containers/{id}/verify", getVerifyHandler, false},
+	{"POST", "/containers/{id}/verify", verifyHandler, false},
 	{"GET", "/update/check", checkUpdateHandler, false},
 	{"GET", "/update/{version}", doUpdateHandler, false},
 	{"GET", "/_/ssh", sshHandler, false},
+	{"GET", "/terminals", getTerminalsHandler, false},
+	{"GET", "/recordings", getRecordingsHandler, false},
+	{"GET", "/recordings/{name}", getRecordingHandler, false},
 	{"GET", "/_/sse", sseHandler, false},
+	{"GET", "/_/events", eventsHandler, false},
+	{"GET", "/_/logs", getLogsHandler, false},
+	{"GET", "/_/metrics", metricsHandler, false},
+	{"GET", "/_/health", healthHandler, false},
+	{"GET", "/_/diagnostics", diagnosticsHandler, false},
+	{"GET", "/_/diagnostics/bundle", supportBundleHandler, false},
 	{"GET", "/env/{ip}", getExportByIPHandler, false},
+	{"GET", "/settings/privacy", getPrivacyHandler, false},
+	{"PUT", "/settings/privacy", updatePrivacyHandler, false},
 }
 
 var renderer = render.New(render.Options{
@@ -58,7 +79,7 @@ func getProjectByIDHandler(rw http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	id := vars["id"]
 
-	project, err := kenmare.GetProject(id)
+	project, err := containerManager.Control.GetProject(id)
 	if err != nil {
 		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
 			"status": requests.StatusFailed,
@@ -74,7 +95,7 @@ func getProjectByIDHandler(rw http.ResponseWriter, req *http.Request) {
 }
 
 func updateProjectByIDHandler(rw http.ResponseWriter, req *http.Request) {
-	addr, _ := sys.GetMACAddress()
+	addr := privacy.MACAddr()
 
 	var project schemas.Project
 	decoder := json.NewDecoder(req.Body)
@@ -87,9 +108,9 @@ func updateProjectByIDHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	log.Println(project)
+	clientLog.Debug("Updating project", "project", project.ID)
 
-	err = kenmare.UpdateProject(addr, &project)
+	err = containerManager.Control.UpdateProject(addr, &project)
 	if err != nil {
 		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
 			"status": requests.StatusFailed,
@@ -103,6 +124,45 @@ func updateProjectByIDHandler(rw http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// getContainersHandler lists the containers being synced.
+func getContainersHandler(rw http.ResponseWriter, req *http.Request) {
+	containers := make([]*schemas.Container, 0, len(containerManager.Containers))
+	for _, container := range containerManager.Containers {
+		containers = append(containers, container)
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":     requests.StatusFound,
+		"containers": containers,
+	})
+}
+
+// getContainerHandler gets a container along with its config, forwards,
+// tunnels and terminals.
+func getContainerHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	container, ok := containerManager.Containers[id]
+	if !ok {
+		renderer.JSON(rw, http.StatusNotFound, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("no container with id %s exists", id),
+		})
+		return
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":    requests.StatusFound,
+		"container": container,
+		"config":    containerManager.Configs[id],
+		"forwards":  containerManager.Forwards.List(id),
+		"tunnels":   containerManager.Tunnels.List(id),
+		"terminals": containerManager.Terminals.List(id),
+		"errors":    containerManager.Errors.List(id),
+	})
+}
+
 // createContainerHandler requests a container from kenmare.io and initiates the
 // sync of the contents of the directory to the container it created.
 func createContainerHandler(rw http.ResponseWriter, req *http.Request) {
@@ -117,52 +177,42 @@ func createContainerHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Determine if the local path has a .bowery file. If so,
-	// use that as the imageID.
-	imageID := ""
-	boweryConfPath := filepath.Join(reqBody.LocalPath, ".bowery")
-	data, err := ioutil.ReadFile(boweryConfPath)
-	if err == nil {
-		imageID = util.FindTokenString(string(data))
+	// Load the .bowery config from the local path, the image id is
+	// used if one exists.
+	conf, err := ReadBoweryConf(reqBody.LocalPath)
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
 	}
+	imageID := conf.ImageID
 
-	// Get the Dockerfile in the local path and use if it there's no .bowery file.
+	// Get the Dockerfile in the local path and use it if there's no image id.
 	dockerfile := ""
-	if req.FormValue("dockerfile") == "true" && imageID == "" {
+	if (req.FormValue("dockerfile") == "true" || conf.Dockerfile != "") && imageID == "" {
 		dockerfilePath := filepath.Join(reqBody.LocalPath, "Dockerfile")
-		data, err = ioutil.ReadFile(dockerfilePath)
+		if conf.Dockerfile != "" {
+			dockerfilePath = filepath.Join(reqBody.LocalPath, filepath.FromSlash(conf.Dockerfile))
+		}
+
+		data, err := ioutil.ReadFile(dockerfilePath)
 		if err == nil {
 			dockerfile = string(data)
+		} else if conf.Dockerfile != "" {
+			renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+				"status": requests.StatusFailed,
+				"error":  err.Error(),
+			})
+			return
 		}
 	}
 
-	// Get name, email, and MAC address in parallel.
-	collaborator := new(schemas.Collaborator)
-	var wg sync.WaitGroup
-	wg.Add(3)
-	go func() {
-		defer wg.Done()
-		cmd := sys.NewCommand("git config user.name", nil)
-		out, _ := cmd.Output()
-		collaborator.Name = strings.Replace(string(out), "\n", "", -1)
-	}()
-
-	go func() {
-		defer wg.Done()
-		cmd := sys.NewCommand("git config user.email", nil)
-		out, _ := cmd.Output()
-		collaborator.Email = strings.Replace(string(out), "\n", "", -1)
-	}()
-
-	go func() {
-		defer wg.Done()
-		addr, _ := sys.GetMACAddress()
-		collaborator.MACAddr = addr
-	}()
+	// Only the identity fields allowed by the privacy settings are sent.
+	collaborator := privacy.Collaborator()
 
-	wg.Wait()
-
-	container, err := kenmare.CreateContainer(imageID, reqBody.LocalPath, dockerfile)
+	container, err := containerManager.Control.CreateContainer(imageID, reqBody.LocalPath, dockerfile)
 	if err != nil {
 		if isNotConnected(err) {
 			err = errors.New("Not Connected")
@@ -176,7 +226,7 @@ func createContainerHandler(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	// Update collaborator.
-	_, err = kenmare.UpdateCollaborator(container.ImageID, collaborator)
+	err = containerManager.Control.UpdateCollaborator(container.ImageID, collaborator)
 	if err != nil {
 		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
 			"status": requests.StatusFailed,
@@ -184,19 +234,31 @@ func createContainerHandler(rw http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
-	containerManager.Add(container)
+	containerManager.Add(container, conf)
+	publishEvent(eventContainer, container.ID, map[string]interface{}{
+		"status":    "created",
+		"container": container,
+	})
 
 	// If the imageID has just been generated, write it to
 	// the application directory.
 	if container.ImageID != imageID {
-		contents := []byte(fmt.Sprintf(boweryFileTmpl, container.ImageID))
-
-		ioutil.WriteFile(boweryConfPath, contents, 0644)
+		conf.ImageID = container.ImageID
+		conf.Legacy = false
+		err = conf.Write(reqBody.LocalPath)
+		if err != nil {
+			renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+				"status": requests.StatusFailed,
+				"error":  err.Error(),
+			})
+			return
+		}
 	}
 
 	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
 		"status":    requests.StatusCreated,
 		"container": container,
+		"config":    conf,
 	})
 }
 
@@ -206,7 +268,7 @@ func deleteContainerHandler(rw http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	id := vars["id"]
 
-	err := kenmare.DeleteContainer(id)
+	err := containerManager.Control.DeleteContainer(id)
 	if err != nil {
 		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
 			"status": requests.StatusFailed,
@@ -223,6 +285,7 @@ func deleteContainerHandler(rw http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
+	publishEvent(eventContainer, id, map[string]string{"status": "removed"})
 
 	renderer.JSON(rw, http.StatusOK, map[string]string{
 		"status": requests.StatusRemoved,
@@ -235,9 +298,7 @@ func updateContainerHandler(rw http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	id := vars["id"]
 
-	addr, _ := sys.GetMACAddress()
-
-	err := kenmare.SaveContainer(id, addr)
+	err := containerManager.Control.SaveContainer(id, privacy.MACAddr())
 	if err != nil {
 		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
 			"status": requests.StatusFailed,
@@ -251,213 +312,1073 @@ func updateContainerHandler(rw http.ResponseWriter, req *http.Request) {
 	})
 }
 
-func doUpdateHandler(rw http.ResponseWriter, req *http.Request) {
+// getForwardsHandler lists the port forwards for a container.
+func getForwardsHandler(rw http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
-	ver := vars["version"]
-	addr := fmt.Sprintf("%s/%s_%s_%s.zip", config.ClientS3Addr, ver, runtime.GOOS, runtime.GOARCH)
-	tmp := filepath.Join(os.TempDir(), "bowery_"+strconv.FormatInt(time.Now().Unix(), 10))
+	id := vars["id"]
 
-	// This is only needed for darwin.
-	if runtime.GOOS != "darwin" {
-		renderer.JSON(rw, http.StatusOK, map[string]string{
-			"status": requests.StatusUpdated,
+	if _, ok := containerManager.Containers[id]; !ok {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("no container with id %s exists", id),
 		})
 		return
 	}
 
-	contents, err := update.DownloadVersion(addr)
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":   requests.StatusFound,
+		"forwards": containerManager.Forwards.List(id),
+	})
+}
+
+// createForwardHandler forwards a local port to a port in the container.
+func createForwardHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	container, ok := containerManager.Containers[id]
+	if !ok {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("no container with id %s exists", id),
+		})
+		return
+	}
+
+	var body struct {
+		LocalPort  int `json:"localPort"`
+		RemotePort int `json:"remotePort"`
+	}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&body)
 	if err != nil {
-		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
 			"status": requests.StatusFailed,
 			"error":  err.Error(),
 		})
 		return
 	}
+	if body.LocalPort == 0 {
+		body.LocalPort = body.RemotePort
+	}
 
-	for info, body := range contents {
-		path := filepath.Join(tmp, info.Name())
-		if info.IsDir() {
-			continue
-		}
+	forward, err := containerManager.Forwards.Add(container, body.LocalPort, body.RemotePort)
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
 
-		err = os.MkdirAll(filepath.Dir(path), os.ModePerm|os.ModeDir)
-		if err != nil {
-			renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
-				"status": requests.StatusFailed,
-				"error":  err.Error(),
-			})
-			return
-		}
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":  requests.StatusCreated,
+		"forward": forward,
+	})
+}
 
-		file, err := os.Create(path)
-		if err != nil {
-			renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
-				"status": requests.StatusFailed,
-				"error":  err.Error(),
-			})
-			return
-		}
-		defer file.Close()
+// deleteForwardsHandler stops the forward for the local port given, or
+// all of the containers forwards if no port is given.
+func deleteForwardsHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
 
-		_, err = io.Copy(file, body)
-		if err != nil {
-			renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
-				"status": requests.StatusFailed,
-				"error":  err.Error(),
-			})
-			return
-		}
+	if req.FormValue("port") == "" {
+		containerManager.Forwards.RemoveAll(id)
+		renderer.JSON(rw, http.StatusOK, map[string]string{
+			"status": requests.StatusRemoved,
+		})
+		return
 	}
 
-	go func() {
-		cmd := sys.NewCommand("open "+filepath.Join(tmp, "bowery.pkg"), nil)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			os.Stderr.Write([]byte(err.Error()))
-		}
-	}()
+	port, err := strconv.Atoi(req.FormValue("port"))
+	if err == nil {
+		err = containerManager.Forwards.Remove(id, port)
+	}
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
 
 	renderer.JSON(rw, http.StatusOK, map[string]string{
-		"status": requests.StatusUpdated,
+		"status": requests.StatusRemoved,
 	})
 }
 
-func checkUpdateHandler(rw http.ResponseWriter, req *http.Request) {
-	newVer, _, err := update.GetLatest(config.ClientS3Addr + "/VERSION")
-	if err != nil {
-		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+// getTunnelsHandler lists the reverse tunnels for a container.
+func getTunnelsHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	if _, ok := containerManager.Containers[id]; !ok {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
 			"status": requests.StatusFailed,
-			"error":  err.Error(),
+			"error":  fmt.Sprintf("no container with id %s exists", id),
 		})
 		return
 	}
 
-	changed, err := update.OutOfDate(VERSION, newVer)
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":  requests.StatusFound,
+		"tunnels": containerManager.Tunnels.List(id),
+	})
+}
+
+// createTunnelHandler forwards a port in the container to a local address.
+func createTunnelHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	container, ok := containerManager.Containers[id]
+	if !ok {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("no container with id %s exists", id),
+		})
+		return
+	}
+
+	var body TunnelConf
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&body)
 	if err != nil {
-		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
 			"status": requests.StatusFailed,
 			"error":  err.Error(),
 		})
 		return
 	}
-
-	body := map[string]string{
-		"status": requests.StatusNoUpdate,
+	if body.LocalAddr == "" {
+		body.LocalAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(body.RemotePort))
 	}
-	if changed {
-		body["status"] = requests.StatusNewUpdate
-		body["version"] = newVer
+
+	tunnel, err := containerManager.Tunnels.Add(container, body.RemotePort, body.LocalAddr)
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
 	}
 
-	renderer.JSON(rw, http.StatusOK, body)
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status": requests.StatusCreated,
+		"tunnel": tunnel.Copy(),
+	})
 }
 
-func sshHandler(rw http.ResponseWriter, req *http.Request) {
-	sshClient := ssh.NewClient(net.JoinHostPort(req.FormValue("ip"), config.DelanceySSHPort))
-	sshClient.User = req.FormValue("user")
-	sshClient.Password = req.FormValue("password")
-	defer sshClient.Close()
+// deleteTunnelsHandler stops the tunnel for the remote port given, or
+// all of the containers tunnels if no port is given.
+func deleteTunnelsHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
 
-	var rows int
-	cols, err := strconv.Atoi(req.FormValue("cols"))
-	if err == nil {
-		rows, err = strconv.Atoi(req.FormValue("rows"))
-	}
-	if err != nil {
-		rw.WriteHeader(500)
-		rw.Write([]byte(err.Error()))
+	if req.FormValue("port") == "" {
+		containerManager.Tunnels.RemoveAll(id)
+		renderer.JSON(rw, http.StatusOK, map[string]string{
+			"status": requests.StatusRemoved,
+		})
 		return
 	}
-	sshClient.Rows = rows
-	sshClient.Cols = cols
 
-	// Setup WebSocket connection.
-	upgrader := &websocket.Upgrader{
-		CheckOrigin: func(req *http.Request) bool { return true },
+	port, err := strconv.Atoi(req.FormValue("port"))
+	if err == nil {
+		err = containerManager.Tunnels.Remove(id, port)
 	}
-	conn, err := upgrader.Upgrade(rw, req, nil)
 	if err != nil {
-		rw.WriteHeader(500)
-		rw.Write([]byte(err.Error()))
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
 		return
 	}
-	defer conn.Close()
-
-	wsio := web.NewWebSocketIO(conn)
-	evDone := make(chan struct{})
-	defer close(evDone)
-
-	// Catch resize events and forward to the shell.
-	go func() {
-		for {
-			select {
-			// Check if we're done.
-			case <-evDone:
-				return
-			case ev := <-wsio.Events:
-				cols, err := strconv.Atoi(ev.Values[0])
-				if err != nil {
-					continue
-				}
-				rows, err := strconv.Atoi(ev.Values[1])
-				if err != nil {
-					continue
-				}
-
-				sshClient.Resize <- &ssh.Resize{
-					Cols: cols,
-					Rows: rows,
-				}
-			}
-		}
-	}()
 
-	// Set stdio for the shell and start it.
-	sshClient.Stdout = wsio
-	sshClient.Stderr = wsio
-	sshClient.Stdin = wsio
-	err = sshClient.Shell()
-	if err != nil && err != websocket.ErrCloseSent {
-		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(1, err.Error()))
-	}
+	renderer.JSON(rw, http.StatusOK, map[string]string{
+		"status": requests.StatusRemoved,
+	})
 }
 
-func sseHandler(rw http.ResponseWriter, req *http.Request) {
-	f, ok := rw.(http.Flusher)
+// execHandler runs a command in the container, streaming its output
+// and exit code back as it runs.
+func execHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	container, ok := containerManager.Containers[id]
 	if !ok {
-		http.Error(rw, "sse not unsupported", http.StatusInternalServerError)
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("no container with id %s exists", id),
+		})
 		return
 	}
 
-	messageChan := make(chan map[string]interface{})
-	ssePool.NewClients <- messageChan
-	defer func() {
-		ssePool.DefunctClients <- messageChan
-	}()
+	var body ExecReq
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&body)
+	if err == nil && strings.TrimSpace(body.Command) == "" {
+		err = errors.New("a command is required")
+	}
+	for key := range body.Env {
+		if err == nil && !envKeyRe.MatchString(key) {
+			err = fmt.Errorf("env key %q is not a valid variable name", key)
+		}
+	}
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
 
-	rw.Header().Set("Content-Type", "text/event-stream")
+	client, err := containerManager.SSH.Get(container)
+	if err != nil {
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+	defer session.Close()
+
+	var env map[string]string
+	if conf, ok := containerManager.Configs[id]; ok {
+		env = conf.Env
+	}
+
+	stream := newExecStream(rw, req)
+	session.Stdout = &execWriter{stream: stream, typ: execStdout}
+	session.Stderr = &execWriter{stream: stream, typ: execStderr}
+
+	// Kill the command if the client goes away.
+	notify := rw.(http.CloseNotifier).CloseNotify()
+	runDone := make(chan struct{})
+	defer close(runDone)
+	go func() {
+		select {
+		case <-notify:
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		case <-runDone:
+		}
+	}()
+
+	exit := &ExecFrame{Type: execExit}
+	err = session.Run(execCommand(&body, env))
+	if err != nil {
+		exitErr, ok := err.(*ssh.ExitError)
+		if ok {
+			exit.Code = exitErr.ExitStatus()
+		} else {
+			exit.Code = -1
+			exit.Error = err.Error()
+		}
+	}
+
+	stream.Send(exit)
+}
+
+// getFilesHandler lists a directory in the container.
+func getFilesHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+	dir := req.FormValue("path")
+	if dir == "" {
+		dir = "."
+	}
+
+	container, ok := containerManager.Containers[id]
+	if !ok {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("no container with id %s exists", id),
+		})
+		return
+	}
+
+	client, err := openSFTP(container)
+	if err != nil {
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+	defer client.Close()
+
+	entries, err := listRemote(client, dir)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+
+		renderer.JSON(rw, status, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":  requests.StatusFound,
+		"path":    dir,
+		"entries": entries,
+	})
+}
+
+// downloadFileHandler sends the contents of a file in the container.
+func downloadFileHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+	path := req.FormValue("path")
+
+	container, ok := containerManager.Containers[id]
+	if !ok || path == "" {
+		err := fmt.Sprintf("no container with id %s exists", id)
+		if ok {
+			err = "a path is required"
+		}
+
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err,
+		})
+		return
+	}
+
+	client, err := openSFTP(container)
+	if err != nil {
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+	defer client.Close()
+
+	file, err := client.Open(path)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+
+		renderer.JSON(rw, status, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = fmt.Errorf("%s is a directory", path)
+	}
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()))
+	rw.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	io.Copy(rw, file)
+}
+
+// uploadFileHandler writes the request body to a file in the container.
+func uploadFileHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+	path := req.FormValue("path")
+
+	container, ok := containerManager.Containers[id]
+	if !ok || path == "" {
+		err := fmt.Sprintf("no container with id %s exists", id)
+		if ok {
+			err = "a path is required"
+		}
+
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err,
+		})
+		return
+	}
+
+	client, err := openSFTP(container)
+	if err != nil {
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+	defer client.Close()
+
+	size, err := uploadRemote(client, path, req.Body)
+	if err != nil {
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status": requests.StatusCreated,
+		"path":   path,
+		"size":   size,
+	})
+}
+
+// verifyHandler starts a job comparing the files in the container with
+// the local path.
+func verifyHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	container, ok := containerManager.Containers[id]
+	if !ok {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("no container with id %s exists", id),
+		})
+		return
+	}
+
+	var body VerifyReq
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&body)
+	if err != nil && err != io.EOF {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	conf := containerManager.Configs[id]
+	if body.RemotePath == "" && conf != nil {
+		body.RemotePath = conf.RemotePath
+	}
+	if !path.IsAbs(body.RemotePath) {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  "remotePath must be the absolute path files are synced to in the container",
+		})
+		return
+	}
+
+	job, err := verifyJobs.Start(container, conf, &body)
+	if err != nil {
+		renderer.JSON(rw, http.StatusConflict, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status": requests.StatusCreated,
+		"job":    job,
+	})
+}
+
+// getSyncErrorsHandler lists the recent sync errors for a container.
+func getSyncErrorsHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	if _, ok := containerManager.Containers[id]; !ok {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("no container with id %s exists", id),
+		})
+		return
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status": requests.StatusFound,
+		"errors": containerManager.Errors.List(id),
+	})
+}
+
+// clearSyncErrorsHandler removes the recent sync errors for a container.
+func clearSyncErrorsHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	containerManager.Errors.Clear(id)
+	renderer.JSON(rw, http.StatusOK, map[string]string{
+		"status": requests.StatusRemoved,
+	})
+}
+
+// getVerifyHandler gets the latest verify job for a container.
+func getVerifyHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	job, ok := verifyJobs.Get(id)
+	if !ok {
+		renderer.JSON(rw, http.StatusNotFound, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  fmt.Sprintf("container %s hasn't been verified", id),
+		})
+		return
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status": requests.StatusFound,
+		"job":    job,
+	})
+}
+
+func doUpdateHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	ver := vars["version"]
+	addr := fmt.Sprintf("%s/%s_%s_%s.zip", config.ClientS3Addr, ver, runtime.GOOS, runtime.GOARCH)
+	tmp := filepath.Join(os.TempDir(), "bowery_"+strconv.FormatInt(time.Now().Unix(), 10))
+
+	// This is only needed for darwin.
+	if runtime.GOOS != "darwin" {
+		renderer.JSON(rw, http.StatusOK, map[string]string{
+			"status": requests.StatusUpdated,
+		})
+		return
+	}
+
+	contents, err := update.DownloadVersion(addr)
+	if err != nil {
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	for info, body := range contents {
+		path := filepath.Join(tmp, info.Name())
+		if info.IsDir() {
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm|os.ModeDir)
+		if err != nil {
+			renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+				"status": requests.StatusFailed,
+				"error":  err.Error(),
+			})
+			return
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+				"status": requests.StatusFailed,
+				"error":  err.Error(),
+			})
+			return
+		}
+		defer file.Close()
+
+		_, err = io.Copy(file, body)
+		if err != nil {
+			renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+				"status": requests.StatusFailed,
+				"error":  err.Error(),
+			})
+			return
+		}
+	}
+
+	go func() {
+		cmd := sys.NewCommand("open "+filepath.Join(tmp, "bowery.pkg"), nil)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			os.Stderr.Write([]byte(err.Error()))
+		}
+	}()
+
+	renderer.JSON(rw, http.StatusOK, map[string]string{
+		"status": requests.StatusUpdated,
+	})
+}
+
+func checkUpdateHandler(rw http.ResponseWriter, req *http.Request) {
+	newVer, _, err := update.GetLatest(config.ClientS3Addr + "/VERSION")
+	if err != nil {
+		updateChecks.Inc("error")
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	changed, err := update.OutOfDate(VERSION, newVer)
+	if err != nil {
+		updateChecks.Inc("error")
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	body := map[string]string{
+		"status": requests.StatusNoUpdate,
+	}
+	if changed {
+		body["status"] = requests.StatusNewUpdate
+		body["version"] = newVer
+	}
+	updateChecks.Inc(body["status"])
+
+	renderer.JSON(rw, http.StatusOK, body)
+}
+
+// sshHandler attaches a WebSocket to a terminal session in a container,
+// starting a new shell if the session doesn't exist. The session lives on
+// after the socket closes so it can be reattached.
+func sshHandler(rw http.ResponseWriter, req *http.Request) {
+	var rows int
+	cols, err := strconv.Atoi(req.FormValue("cols"))
+	if err == nil {
+		rows, err = strconv.Atoi(req.FormValue("rows"))
+	}
+	if err == nil {
+		err = (&ControlMsg{Type: controlResize, Cols: cols, Rows: rows}).Validate()
+	}
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(err.Error()))
+		return
+	}
+
+	container, ok := containerManager.Containers[req.FormValue("id")]
+	if !ok {
+		container = nil
+		for _, cont := range containerManager.Containers {
+			if cont.Address == req.FormValue("ip") {
+				container = cont
+				break
+			}
+		}
+	}
+	if container == nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("no container found for the terminal"))
+		return
+	}
+
+	// Sessions can only be reattached from the container they belong to.
+	term, ok := containerManager.Terminals.Get(req.FormValue("session"))
+	if ok && term.ContainerID != container.ID {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("terminal session belongs to another container"))
+		return
+	}
+
+	// Setup WebSocket connection.
+	upgrader := &websocket.Upgrader{
+		CheckOrigin: checkOrigin,
+	}
+	conn, err := upgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		rw.WriteHeader(500)
+		rw.Write([]byte(err.Error()))
+		return
+	}
+	socket := NewTermSocket(conn)
+	defer socket.Close()
+
+	// A new shell is started once a message other than env is received,
+	// so the env can be set before it starts.
+	if term == nil {
+		var env map[string]string
+		var input [][]byte
+		var controls []*ControlMsg
+		wait := time.After(250 * time.Millisecond)
+
+	handshake:
+		for {
+			select {
+			case <-socket.Done():
+				return
+			case <-wait:
+				break handshake
+			case data := <-socket.Input:
+				input = append(input, data)
+				break handshake
+			case msg := <-socket.Control:
+				if msg.Type != controlEnv {
+					controls = append(controls, msg)
+					break handshake
+				}
+
+				if env == nil {
+					env = make(map[string]string)
+				}
+				for key, val := range msg.Env {
+					env[key] = val
+				}
+			}
+		}
+
+		// The env from the socket takes precedence over the config.
+		if conf, ok := containerManager.Configs[container.ID]; ok && len(conf.Env) > 0 {
+			confEnv := make(map[string]string, len(conf.Env)+len(env))
+			for key, val := range conf.Env {
+				confEnv[key] = val
+			}
+			for key, val := range env {
+				confEnv[key] = val
+			}
+			env = confEnv
+		}
+
+		term, err = containerManager.Terminals.Open(container, req.FormValue("session"), rows, cols, env)
+		if err != nil {
+			socket.CloseWithError(err)
+			return
+		}
+
+		for _, data := range input {
+			term.Write(data)
+		}
+		for _, msg := range controls {
+			handleTermControl(term, socket, msg)
+		}
+	} else {
+		term.Resize(rows, cols)
+	}
+
+	err = term.Attach(socket, socket)
+	if err != nil {
+		socket.CloseWithError(err)
+		return
+	}
+	defer term.Detach(socket)
+
+	// Send input to the shell until the socket closes or the shell exits.
+	for {
+		select {
+		case data := <-socket.Input:
+			_, err := term.Write(data)
+			if err != nil {
+				socket.SendError(err)
+			}
+		case msg := <-socket.Control:
+			handleTermControl(term, socket, msg)
+		case <-socket.Done():
+			return
+		case <-term.Done():
+			return
+		case <-closing:
+			socket.CloseGoingAway("client is shutting down")
+			return
+		}
+	}
+}
+
+// handleTermControl applies a control message to a terminal.
+func handleTermControl(term *Terminal, socket *TermSocket, msg *ControlMsg) {
+	var err error
+
+	switch msg.Type {
+	case controlResize:
+		err = term.Resize(msg.Rows, msg.Cols)
+	case controlSignal:
+		err = term.Signal(termSignals[msg.Signal])
+	case controlEnv:
+		err = errors.New("env can only be set before the shell starts")
+	}
+
+	if err != nil {
+		socket.SendError(err)
+	}
+}
+
+// getTerminalsHandler lists the live terminal sessions, optionally only
+// those for the container given.
+func getTerminalsHandler(rw http.ResponseWriter, req *http.Request) {
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":    requests.StatusFound,
+		"terminals": containerManager.Terminals.List(req.FormValue("container")),
+	})
+}
+
+// getLogsHandler returns the recent log entries, filtered by level and
+// subsystem, as logfmt lines or JSON if format=json.
+func getLogsHandler(rw http.ResponseWriter, req *http.Request) {
+	var err error
+	lines := 200
+	level := logger.DebugLevel
+	if req.FormValue("lines") != "" {
+		lines, err = strconv.Atoi(req.FormValue("lines"))
+		if err == nil && lines < 1 {
+			err = errors.New("lines must be positive")
+		}
+	}
+	if err == nil && req.FormValue("level") != "" {
+		level, err = logger.ParseLevel(req.FormValue("level"))
+	}
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	entries := logger.Recent(lines, level, req.FormValue("subsystem"))
+	if req.FormValue("format") == logger.JSONFormat {
+		renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+			"status":  requests.StatusFound,
+			"entries": entries,
+		})
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, entry := range entries {
+		fmt.Fprintln(rw, entry.Line())
+	}
+}
+
+// metricsHandler writes the metrics in the Prometheus text format.
+func metricsHandler(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	err := WriteMetrics(rw)
+	if err != nil {
+		clientLog.Warn("Writing metrics failed", "error", err)
+	}
+}
+
+// healthHandler returns the health without checking the network.
+func healthHandler(rw http.ResponseWriter, req *http.Request) {
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status": requests.StatusFound,
+		"health": GetHealth(),
+	})
+}
+
+// diagnosticsHandler checks kenmare and the containers can be reached,
+// along with the sync state and disk space for each container.
+func diagnosticsHandler(rw http.ResponseWriter, req *http.Request) {
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":      requests.StatusFound,
+		"diagnostics": GetDiagnostics(),
+	})
+}
+
+// supportBundleHandler downloads a bundle with the diagnostics and logs
+// to attach to support requests.
+func supportBundleHandler(rw http.ResponseWriter, req *http.Request) {
+	diag := GetDiagnostics()
+	name := "bowery-support-" + diag.Time.Format("20060102-150405") + ".tar.gz"
+
+	rw.Header().Set("Content-Type", "application/x-gzip")
+	rw.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+	err := WriteSupportBundle(rw, diag)
+	if err != nil {
+		clientLog.Error("Writing support bundle failed", "error", err)
+	}
+}
+
+// getPrivacyHandler returns the privacy settings and the collaborator
+// details they allow to be sent.
+func getPrivacyHandler(rw http.ResponseWriter, req *http.Request) {
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":       requests.StatusFound,
+		"settings":     privacy.Get(),
+		"collaborator": privacy.Collaborator(),
+	})
+}
+
+// updatePrivacyHandler updates the privacy settings, fields not given are
+// left as they are.
+func updatePrivacyHandler(rw http.ResponseWriter, req *http.Request) {
+	settings := privacy.Get()
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&settings)
+	if err == nil {
+		err = privacy.Set(settings)
+	}
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":   requests.StatusUpdated,
+		"settings": settings,
+	})
+}
+
+// getRecordingsHandler lists the recorded terminal sessions.
+func getRecordingsHandler(rw http.ResponseWriter, req *http.Request) {
+	if recordDir == "" {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  "terminal recording is disabled",
+		})
+		return
+	}
+
+	recordings, err := ListRecordings(recordDir)
+	if err != nil {
+		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	renderer.JSON(rw, http.StatusOK, map[string]interface{}{
+		"status":     requests.StatusFound,
+		"recordings": recordings,
+	})
+}
+
+// getRecordingHandler sends the asciicast file for a recording.
+func getRecordingHandler(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	name := vars["name"]
+
+	path, err := RecordingPath(recordDir, name)
+	if err == nil && recordDir == "" {
+		err = errors.New("terminal recording is disabled")
+	}
+	if err != nil {
+		renderer.JSON(rw, http.StatusBadRequest, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+
+		renderer.JSON(rw, status, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	rw.Header().Set("Content-Type", "application/x-asciicast")
+	io.Copy(rw, file)
+}
+
+// sseHandler streams events to the client, filtered by the container and
+// type params. Events missed since the Last-Event-ID are replayed first.
+func sseHandler(rw http.ResponseWriter, req *http.Request) {
+	f, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "sse not unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := eventHub.Subscribe(NewEventFilter(req))
+	defer eventHub.Unsubscribe(sub)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
 	rw.Header().Set("Cache-Control", "no-cache")
 	rw.Header().Set("Connection", "keep-alive")
 
+	// Events already replayed are skipped when they come from the hub.
+	writeSSE := func(ev *StreamEvent) error {
+		_, err := ev.WriteTo(rw)
+		return err
+	}
+
+	sent := sub.LastID
+	if id, ok := lastEventID(req); ok {
+		var err error
+		sent, err = eventHub.Replay(sub, id, writeSSE)
+		if err != nil {
+			return
+		}
+	}
+	f.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
 	notify := rw.(http.CloseNotifier).CloseNotify()
 	for {
+		var err error
+
 		select {
+		case <-closing:
+			return
 		case <-notify:
-			break
-		case msg := <-messageChan:
-			log.Println(msg)
-
-			data, err := json.Marshal(msg)
-			if err != nil {
-				return
+			return
+		case <-heartbeat.C:
+			_, err = io.WriteString(rw, ": heartbeat\n\n")
+		case <-sub.Lagged:
+			sent, err = eventHub.Replay(sub, sent, writeSSE)
+		case ev := <-sub.Events:
+			if ev.ID <= sent {
+				continue
 			}
 
-			fmt.Fprintf(rw, "data: %v\n\n", string(data))
-			f.Flush()
+			sent = ev.ID
+			_, err = ev.WriteTo(rw)
+		}
+		if err != nil {
+			return
 		}
+		f.Flush()
+	}
+}
+
+// eventsHandler sends the same events as sseHandler over a WebSocket, the
+// containers can be changed with subscribe and unsubscribe messages.
+func eventsHandler(rw http.ResponseWriter, req *http.Request) {
+	upgrader := &websocket.Upgrader{
+		CheckOrigin: checkOrigin,
+	}
+	conn, err := upgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		return
 	}
+
+	sub := eventHub.Subscribe(NewEventFilter(req))
+	defer eventHub.Unsubscribe(sub)
+
+	id, replay := lastEventID(req)
+	NewEventSocket(conn, sub).Run(id, replay)
 }
 
 func getExportByIPHandler(rw http.ResponseWriter, req *http.Request) {
@@ -481,7 +1402,7 @@ func getExportByIPHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	export, err := kenmare.Export(container.ImageID)
+	export, err := containerManager.Control.Export(container.ImageID)
 	if err != nil {
 		renderer.JSON(rw, http.StatusInternalServerError, map[string]string{
 			"status": requests.StatusFailed,
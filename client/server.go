@@ -0,0 +1,215 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Bowery/gopackages/requests"
+	"github.com/Bowery/gopackages/sys"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// allowedOrigins are the browser origins allowed to call the api, the
+// shell loads its pages from the filesystem.
+var allowedOrigins = []string{"file://", "null"}
+
+// Route describes an api route, public routes don't require the token.
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+	Public  bool
+}
+
+// Server serves the api routes, requiring the token on every non public
+// route and rejecting requests from other browser origins.
+type Server struct {
+	Token      string
+	router     *mux.Router
+	httpServer *http.Server
+}
+
+// NewServer creates a server for the routes.
+func NewServer(routes []Route, token string) *Server {
+	server := &Server{Token: token, router: mux.NewRouter()}
+	server.httpServer = &http.Server{Handler: server}
+
+	for _, route := range routes {
+		handler := route.Handler
+		if !route.Public {
+			handler = server.requireToken(handler)
+		}
+
+		server.router.HandleFunc(route.Path, handler).Methods(route.Method)
+	}
+
+	return server
+}
+
+// ServeHTTP checks the origin and routes the request.
+func (server *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if origin != "" && !isAllowedOrigin(origin) {
+		renderer.JSON(rw, http.StatusForbidden, map[string]string{
+			"status": requests.StatusFailed,
+			"error":  "origin " + origin + " is not allowed",
+		})
+		return
+	}
+
+	// Remove trailing slashes so routes match either way.
+	if req.URL.Path != "/" {
+		req.URL.Path = strings.TrimRight(req.URL.Path, "/")
+	}
+
+	server.router.ServeHTTP(rw, req)
+}
+
+// Listen opens the listeners for the tcp address and the unix socket,
+// either may be empty to disable it. The socket is only accessible by
+// the current user.
+func Listen(addr, socket string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, 2)
+	if addr == "" && socket == "" {
+		return nil, errors.New("a tcp address or unix socket is required")
+	}
+
+	if addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if socket != "" {
+		// Remove a socket left by a previous run, anything else is left alone.
+		info, err := os.Lstat(socket)
+		if err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				err = fmt.Errorf("%s already exists and isn't a socket", socket)
+			} else {
+				err = os.Remove(socket)
+			}
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err == nil {
+			var listener net.Listener
+			listener, err = listenUnix(socket)
+			if err == nil {
+				listeners = append(listeners, listener)
+				err = os.Chmod(socket, 0600)
+			}
+		}
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+
+			return nil, err
+		}
+	}
+
+	return listeners, nil
+}
+
+// Serve serves the api on the listeners until one of them fails.
+func (server *Server) Serve(listeners []net.Listener) error {
+	errChan := make(chan error, len(listeners))
+
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errChan <- server.httpServer.Serve(listener)
+		}(listener)
+	}
+
+	return <-errChan
+}
+
+// Shutdown closes the listeners and waits for the in-flight requests to
+// finish, or for the context to end.
+func (server *Server) Shutdown(ctx context.Context) error {
+	return server.httpServer.Shutdown(ctx)
+}
+
+// requireToken wraps a handler requiring the token in the Authorization
+// header, or the token query param for WebSockets and event streams.
+func (server *Server) requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		// Browsers can't set headers on WebSockets and event streams.
+		token := ""
+		if websocket.IsWebSocketUpgrade(req) || req.URL.Path == "/_/sse" {
+			token = req.URL.Query().Get("token")
+		}
+		auth := req.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(server.Token)) != 1 {
+			renderer.JSON(rw, http.StatusUnauthorized, map[string]string{
+				"status": requests.StatusFailed,
+				"error":  "invalid or missing token",
+			})
+			return
+		}
+
+		handler(rw, req)
+	}
+}
+
+// checkOrigin checks the origin of a WebSocket request, requests from
+// outside a browser don't send one.
+func checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+
+	return origin == "" || isAllowedOrigin(origin)
+}
+
+// isAllowedOrigin checks if a browser origin may call the api.
+func isAllowedOrigin(origin string) bool {
+	for _, allowed := range allowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenPath is the file the token is written to for the shell.
+func tokenPath() string {
+	return filepath.Join(os.Getenv(sys.HomeVar), ".bowery_token")
+}
+
+// newToken generates a random token and writes it to path, only the
+// current user can read it.
+func newToken(path string) (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	// Remove any previous file so the permissions are applied.
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	return token, ioutil.WriteFile(path, []byte(token), 0600)
+}
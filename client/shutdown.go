@@ -0,0 +1,60 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// closing is closed when the client starts shutting down, long lived
+// connections like event streams and terminals close when it is.
+var closing = make(chan struct{})
+
+// shutdown stops accepting requests, closes the long lived connections,
+// and waits for the in-flight requests and syncs to finish. If it takes
+// longer than timeout an error is returned, a second signal on signals
+// ends it early.
+func shutdown(server *Server, timeout time.Duration, signals <-chan os.Signal) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	close(closing)
+
+	done := make(chan error, 1)
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			err := server.Shutdown(ctx)
+			if err != nil {
+				clientLog.Error("Server shutdown failed", "error", err)
+			}
+		}()
+
+		// Each watcher finishes its current pass and syncs what's pending.
+		go func() {
+			defer wg.Done()
+			err := containerManager.Syncer.Close()
+			if err != nil {
+				syncLog.Error("Flushing changes failed", "error", err)
+			}
+		}()
+
+		// The rest is closed once requests using the connections finish.
+		wg.Wait()
+		done <- containerManager.Close()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.New("shutdown took longer than " + timeout.String() + ", pending changes may not be synced")
+	case sig := <-signals:
+		return errors.New("received " + sig.String() + " while shutting down, pending changes may not be synced")
+	}
+}
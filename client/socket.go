@@ -0,0 +1,22 @@
+// Copyright 2015 Bowery, Inc.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix listens on a unix socket that's created with only user
+// permissions, so it's never connectable by others.
+func listenUnix(path string) (net.Listener, error) {
+	// The umask is process wide, this runs at startup before anything
+	// else creates files.
+	mask := syscall.Umask(0177)
+	defer syscall.Umask(mask)
+
+	return net.Listen("unix", path)
+}
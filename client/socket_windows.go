@@ -0,0 +1,11 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"net"
+)
+
+// listenUnix listens on a unix socket.
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
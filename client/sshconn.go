@@ -0,0 +1,214 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Bowery/desktop/logger"
+	"github.com/Bowery/gopackages/config"
+	"github.com/Bowery/gopackages/schemas"
+	"github.com/Bowery/gopackages/sys"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var sshLog = logger.New("ssh")
+
+// sshKeyFiles are the private keys in ~/.ssh tried for authentication,
+// these are the pairs uploaded to containers by the agent.
+var sshKeyFiles = []string{"id_rsa", "id_ecdsa", "id_ed25519", "id_dsa"}
+
+// sshPasswordFallback enables password authentication when the keys fail.
+var sshPasswordFallback = false
+
+// knownHostsMutex serializes access to the pinned host keys.
+var knownHostsMutex sync.Mutex
+
+// SSHPool keeps a single ssh connection open to each container so
+// forwards and commands can share it.
+type SSHPool struct {
+	conns map[string]*ssh.Client
+	mutex sync.Mutex
+}
+
+// NewSSHPool creates an SSHPool.
+func NewSSHPool() *SSHPool {
+	return &SSHPool{conns: make(map[string]*ssh.Client)}
+}
+
+// Get returns the connection for a container, dialing if needed.
+func (pool *SSHPool) Get(container *schemas.Container) (*ssh.Client, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	client, ok := pool.conns[container.ID]
+	if ok {
+		return client, nil
+	}
+
+	client, err := dialSSH(container)
+	if err != nil {
+		return nil, err
+	}
+	pool.conns[container.ID] = client
+
+	// Drop the connection once it dies so the next Get redials.
+	go func() {
+		client.Wait()
+
+		pool.mutex.Lock()
+		if pool.conns[container.ID] == client {
+			delete(pool.conns, container.ID)
+		}
+		pool.mutex.Unlock()
+	}()
+
+	return client, nil
+}
+
+// Reset closes the connection for a container, the next Get redials.
+func (pool *SSHPool) Reset(id string) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	client, ok := pool.conns[id]
+	if ok {
+		client.Close()
+		delete(pool.conns, id)
+	}
+}
+
+// Close closes all the connections.
+func (pool *SSHPool) Close() error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for id, client := range pool.conns {
+		client.Close()
+		delete(pool.conns, id)
+	}
+
+	return nil
+}
+
+// dialSSH opens an ssh connection to the agent in a container. Keys from
+// the ssh agent and ~/.ssh are used, the containers password is only
+// tried if the password fallback is enabled.
+func dialSSH(container *schemas.Container) (*ssh.Client, error) {
+	if container.Address == "" {
+		return nil, fmt.Errorf("container %s isn't running yet", container.ID)
+	}
+
+	auth := make([]ssh.AuthMethod, 0)
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			defer conn.Close()
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	signers := sshSigners(filepath.Join(os.Getenv(sys.HomeVar), ".ssh"))
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	if sshPasswordFallback && container.Password != "" {
+		auth = append(auth, ssh.PasswordCallback(func() (string, error) {
+			sshLog.Warn("Key authentication failed, using password", "container", container.ID)
+			return container.Password, nil
+		}))
+	}
+	if len(auth) <= 0 {
+		return nil, errors.New("no ssh keys found and password authentication is disabled")
+	}
+
+	return ssh.Dial("tcp", net.JoinHostPort(container.Address, config.DelanceySSHPort), &ssh.ClientConfig{
+		User:            container.User,
+		Auth:            auth,
+		HostKeyCallback: pinHostKey(container.ID),
+		Timeout:         10 * time.Second,
+	})
+}
+
+// knownHostsPath is the file container host keys are pinned in.
+func knownHostsPath() string {
+	return filepath.Join(os.Getenv(sys.HomeVar), ".bowery", "known_hosts")
+}
+
+// pinHostKey returns a host key callback that trusts the key a container
+// presents the first time and rejects any other key after that. Keys are
+// pinned by container id since addresses get reused.
+func pinHostKey(id string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMutex.Lock()
+		defer knownHostsMutex.Unlock()
+		path := knownHostsPath()
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) != 2 || fields[0] != id {
+				continue
+			}
+
+			pinned, _, _, _, err := ssh.ParseAuthorizedKey([]byte(fields[1]))
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(pinned.Marshal(), key.Marshal()) {
+				return fmt.Errorf("host key for container %s has changed, remove it from %s if the container was recreated", id, path)
+			}
+
+			return nil
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = file.Write(append([]byte(id+" "), ssh.MarshalAuthorizedKey(key)...))
+		return err
+	}
+}
+
+// sshSigners loads the unencrypted private keys in a directory.
+func sshSigners(dir string) []ssh.Signer {
+	signers := make([]ssh.Signer, 0)
+
+	for _, name := range sshKeyFiles {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		// Encrypted keys can only be used through the ssh agent.
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, signer)
+	}
+
+	return signers
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"os"
 	"path/filepath"
@@ -33,55 +34,92 @@ type Event struct {
 	Paths     []string           `json:"paths"`
 }
 
-// WatchError wraps an error to identify the container origin.
+// WatchError wraps an error to identify the container origin, the path it
+// occurred for if any, and what kind of error it is.
 type WatchError struct {
-	Container *schemas.Container `json:"container"`
-	Err       error              `json:"error"`
+	Container *schemas.Container
+	Path      string
+	Kind      string
+	Time      time.Time
+	Err       error
 }
 
 func (w *WatchError) Error() string {
 	return w.Err.Error()
 }
 
+// MarshalJSON encodes the error with the container id.
+func (w *WatchError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"containerID": w.Container.ID,
+		"path":        w.Path,
+		"kind":        w.Kind,
+		"time":        w.Time,
+		"error":       w.Err.Error(),
+	})
+}
+
 // Watcher syncs file changes for a container to it's remote address.
 type Watcher struct {
 	Container *schemas.Container
+	Config    *BoweryConf
+	agent     Agent
 	mutex     sync.Mutex
 	done      chan struct{}
+	stopped   chan struct{}
+	uploaded  chan struct{}
 	isDone    bool
+	flush     bool
+	failed    bool
+	watching  bool
 }
 
-// NewWatcher creates a watcher.
-func NewWatcher(container *schemas.Container) *Watcher {
+// States a watcher can be in.
+const (
+	watcherUploading = "uploading"
+	watcherWatching  = "watching"
+	watcherFailed    = "failed"
+	watcherStopped   = "stopped"
+)
+
+// NewWatcher creates a watcher, conf may be nil to use the defaults.
+func NewWatcher(container *schemas.Container, conf *BoweryConf, agent Agent) *Watcher {
 	var mutex sync.Mutex
 
 	return &Watcher{
 		Container: container,
+		Config:    conf,
+		agent:     agent,
 		mutex:     mutex,
 		done:      make(chan struct{}),
+		uploaded:  make(chan struct{}),
 	}
 }
 
 // Start syncs file changes and uploads to the applications remote address.
+// Nothing is done if the watcher was already closed.
 func (watcher *Watcher) Start(evChan chan *Event, errChan chan error) {
 	var found []string
 	stats := make(map[string]os.FileInfo)
 	updates := make([]*updateEvent, 0)
 	local := watcher.Container.LocalPath
 
-	// If previously called Close reset the state.
 	watcher.mutex.Lock()
 	if watcher.isDone {
-		watcher.isDone = false
-		watcher.done = make(chan struct{})
+		watcher.mutex.Unlock()
+		return
 	}
+	stopped := make(chan struct{})
+	watcher.stopped = stopped
 	watcher.mutex.Unlock()
+	defer close(stopped)
 
 	ignoreList, err := ignores.Get(filepath.Join(local, config.IgnorePath))
 	if err != nil {
 		errChan <- watcher.wrapErr(err)
 		ignoreList = make([]string, 0)
 	}
+	ignoreList = append(ignoreList, watcher.Config.IgnorePaths(local)...)
 
 	// Get initial stats.
 	err = filepath.Walk(local, func(path string, info os.FileInfo, err error) error {
@@ -111,10 +149,15 @@ func (watcher *Watcher) Start(evChan chan *Event, errChan chan error) {
 		errChan <- watcher.wrapErr(err)
 	}
 
+	// Changes made from here on are synced.
+	watcher.mutex.Lock()
+	watcher.watching = true
+	watcher.mutex.Unlock()
+
 	// Manages updates/creates.
 	walker := func(path string, info os.FileInfo, err error) error {
 		if err != nil && !os.IsNotExist(err) {
-			errChan <- watcher.wrapErr(err)
+			errChan <- watcher.wrapPathErr(err, path)
 			return nil
 		}
 		if err != nil || local == path {
@@ -212,11 +255,14 @@ func (watcher *Watcher) Start(evChan chan *Event, errChan chan error) {
 				continue
 			}
 
+			start := time.Now()
 			err = watcher.Update(rel, delancey.DeleteStatus)
+			syncUploadDuration.ObserveSince(uploadDelete, start)
 			if err != nil {
-				errChan <- watcher.wrapErr(err)
+				errChan <- watcher.wrapAgentErr(err, rel)
 				continue
 			}
+			syncFiles.Inc(delancey.DeleteStatus)
 
 			evChan <- &Event{Container: watcher.Container, Status: delancey.DeleteStatus, Paths: []string{rel}}
 		}
@@ -228,19 +274,34 @@ func (watcher *Watcher) Start(evChan chan *Event, errChan chan error) {
 		found = util.RemoveFromSlice(found, path)
 	}
 
+	// Gets the size of a file for the metrics, directories have none.
+	fileSize := func(path string) float64 {
+		info, ok := stats[path]
+		if !ok || info.IsDir() {
+			return 0
+		}
+
+		return float64(info.Size())
+	}
+
 	// Standard update, does them one at a time.
 	standardUpdate := func() {
 		for _, ev := range updates {
+			size := fileSize(ev.Path)
+			start := time.Now()
 			err = watcher.Update(ev.Rel, ev.Status)
+			syncUploadDuration.ObserveSince(uploadFile, start)
 			if err != nil {
 				if os.IsNotExist(err) {
 					removeTemp(ev.Path)
 					continue
 				}
 
-				errChan <- watcher.wrapErr(err)
+				errChan <- watcher.wrapAgentErr(err, ev.Rel)
 				continue
 			}
+			syncFiles.Inc(ev.Status)
+			syncBytes.Add(uploadFile, size)
 
 			evChan <- &Event{Container: watcher.Container, Status: ev.Status, Paths: []string{ev.Rel}}
 		}
@@ -251,11 +312,14 @@ func (watcher *Watcher) Start(evChan chan *Event, errChan chan error) {
 		batchChan := make(chan error)
 		pathList := make([]string, 0, len(updates))
 		paths := make(map[string]string, len(updates))
+		size := float64(0)
 
 		for _, ev := range updates {
 			pathList = append(pathList, ev.Path)
 			paths[ev.Path] = ev.Rel
+			size += fileSize(ev.Path)
 		}
+		syncBatchSize.Observe("", float64(len(updates)))
 
 		go func() {
 			for err := range batchChan {
@@ -264,40 +328,47 @@ func (watcher *Watcher) Start(evChan chan *Event, errChan chan error) {
 					removeTemp(berr.Path)
 					continue
 				}
+				if ok {
+					errChan <- watcher.wrapAgentErr(berr.Err, berr.Path)
+					continue
+				}
 
-				errChan <- watcher.wrapErr(err)
+				errChan <- watcher.wrapAgentErr(err, "")
 			}
 		}()
 
 		evChan <- &Event{Container: watcher.Container, Status: delancey.BatchStartStatus, Paths: pathList}
-		err := delancey.BatchUpdate(watcher.Container, paths, batchChan)
+		start := time.Now()
+		err := watcher.agent.BatchUpdate(watcher.Container, paths, batchChan)
+		syncUploadDuration.ObserveSince(uploadBatch, start)
 		if err != nil {
-			errChan <- watcher.wrapErr(err)
+			errChan <- watcher.wrapAgentErr(err, "")
 			return
 		}
+		for _, ev := range updates {
+			syncFiles.Inc(ev.Status)
+		}
+		syncBytes.Add(uploadBatch, size)
 
 		evChan <- &Event{Container: watcher.Container, Status: delancey.BatchFinishStatus, Paths: pathList}
 	}
 
-	for {
-		// Check if we're done.
-		select {
-		case <-watcher.done:
-			return
-		default:
-		}
-
+	// Syncs the changes since the last pass.
+	syncChanges := func() {
 		ignoreList, err = ignores.Get(filepath.Join(local, config.IgnorePath))
 		if err != nil {
 			errChan <- watcher.wrapErr(err)
 			ignoreList = make([]string, 0)
 		}
+		ignoreList = append(ignoreList, watcher.Config.IgnorePaths(local)...)
 
+		start := time.Now()
 		err = filepath.Walk(local, walker)
+		syncWalkDuration.ObserveSince("", start)
 		if err != nil {
 			errChan <- watcher.wrapErr(err)
 		}
-		isBatchJob := len(updates) > 16
+		isBatchJob := len(updates) > watcher.Config.BatchThreshold()
 
 		// Do the create/update uploads.
 		if isBatchJob {
@@ -309,7 +380,25 @@ func (watcher *Watcher) Start(evChan chan *Event, errChan chan error) {
 		checkDeletes()
 		updates = make([]*updateEvent, 0)
 		found = make([]string, 0)
-		<-time.After(500 * time.Millisecond)
+	}
+
+	for {
+		// Check if we're done, doing a final pass if flushing.
+		select {
+		case <-watcher.done:
+			if watcher.isFlushing() {
+				syncChanges()
+			}
+			return
+		default:
+		}
+
+		syncChanges()
+
+		select {
+		case <-watcher.done:
+		case <-time.After(watcher.Config.SyncInterval()):
+		}
 	}
 }
 
@@ -325,6 +414,7 @@ func (watcher *Watcher) Upload() error {
 	if err != nil {
 		return watcher.wrapErr(err)
 	}
+	ignoreList = append(ignoreList, watcher.Config.IgnorePaths(local)...)
 
 	// Tar up the path and write to a type supporting seeking.
 	upload, err := tar.Tar(local, ignoreList)
@@ -345,26 +435,31 @@ func (watcher *Watcher) Upload() error {
 			return watcher.wrapErr(err)
 		}
 
-		err = delancey.Upload(watcher.Container, uploadContents)
+		start := time.Now()
+		err = watcher.agent.Upload(watcher.Container, uploadContents)
+		syncUploadDuration.ObserveSince(uploadFull, start)
 		if err == nil {
+			syncBytes.Add(uploadFull, float64(buf.Len()))
 			return nil
 		}
 
 		i++
+		syncRetries.Inc("")
 		<-time.After(time.Millisecond * 50)
 	}
 
-	return watcher.wrapErr(err)
+	return watcher.wrapAgentErr(err, "")
 }
 
 // Update updates a path to the containers remote address.
 func (watcher *Watcher) Update(name, status string) error {
 	path := filepath.Join(watcher.Container.LocalPath, name)
 
-	err := delancey.Update(watcher.Container, path, name, status)
+	err := watcher.agent.Update(watcher.Container, path, name, status)
 	if err != nil && strings.Contains(err.Error(), "invalid app id") {
 		// If the id is invalid that indicates the server died, just reupload
 		// and try again.
+		syncRetries.Inc("")
 		err = watcher.Upload()
 		if err != nil {
 			we, ok := err.(*WatchError)
@@ -375,45 +470,131 @@ func (watcher *Watcher) Update(name, status string) error {
 			return err
 		}
 
-		return delancey.Update(watcher.Container, path, name, status)
+		return watcher.agent.Update(watcher.Container, path, name, status)
 	}
 
 	return err
 }
 
-// Close closes the watcher and removes existing upload paths.
-func (watcher *Watcher) Close() error {
+// Uploaded returns a channel that's closed once the initial upload is done,
+// whether or not it failed.
+func (watcher *Watcher) Uploaded() <-chan struct{} {
+	return watcher.uploaded
+}
+
+// State returns whether the watcher is doing the initial upload, watching
+// for changes, failed the initial upload or has been stopped.
+func (watcher *Watcher) State() string {
 	watcher.mutex.Lock()
 	defer watcher.mutex.Unlock()
 
+	switch {
+	case watcher.isDone:
+		return watcherStopped
+	case watcher.failed:
+		return watcherFailed
+	case !watcher.watching:
+		return watcherUploading
+	}
+
+	return watcherWatching
+}
+
+// Close stops syncing, waiting for the pass in progress to finish.
+func (watcher *Watcher) Close() error {
+	return watcher.stop(false)
+}
+
+// Flush syncs the changes made since the last pass and stops syncing.
+func (watcher *Watcher) Flush() error {
+	return watcher.stop(true)
+}
+
+// stop stops the sync loop and waits for it to return, a watcher doing
+// the initial upload is waited for first.
+func (watcher *Watcher) stop(flush bool) error {
+	watcher.mutex.Lock()
 	if watcher.isDone {
+		watcher.mutex.Unlock()
 		return nil
 	}
-	close(watcher.done)
+	watcher.flush = flush
 	watcher.isDone = true
+	close(watcher.done)
+	watcher.mutex.Unlock()
+
+	<-watcher.uploaded
+	watcher.mutex.Lock()
+	stopped := watcher.stopped
+	watcher.mutex.Unlock()
+
+	if stopped != nil {
+		<-stopped
+	}
 
 	return nil
 }
 
+// isFlushing checks if the watcher was stopped with Flush.
+func (watcher *Watcher) isFlushing() bool {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	return watcher.flush
+}
+
 // wrapErr wraps an error with the application it occurred for.
 func (watcher *Watcher) wrapErr(err error) error {
+	return watcher.newWatchError(err, "", false)
+}
+
+// wrapPathErr wraps an error that occurred for a local path.
+func (watcher *Watcher) wrapPathErr(err error, path string) error {
+	return watcher.newWatchError(err, path, false)
+}
+
+// wrapAgentErr wraps an error from a request to the agent, path is the
+// path being synced if any.
+func (watcher *Watcher) wrapAgentErr(err error, path string) error {
+	return watcher.newWatchError(err, path, true)
+}
+
+// newWatchError creates a classified WatchError, paths are made relative
+// to the local path.
+func (watcher *Watcher) newWatchError(err error, path string, fromAgent bool) error {
 	if err == nil {
 		return nil
 	}
 
-	return &WatchError{Container: watcher.Container, Err: err}
+	if filepath.IsAbs(path) {
+		rel, relErr := filepath.Rel(watcher.Container.LocalPath, path)
+		if relErr == nil {
+			path = rel
+		}
+	}
+
+	return &WatchError{
+		Container: watcher.Container,
+		Path:      filepath.ToSlash(path),
+		Kind:      classifyError(err, fromAgent),
+		Time:      time.Now(),
+		Err:       err,
+	}
 }
 
 // Syncer manages the syncing of a list of file watchers.
 type Syncer struct {
-	Event    chan *Event
-	Error    chan error
-	Watchers []*Watcher
+	Agent     Agent
+	Event     chan *Event
+	Error     chan error
+	Watchers  []*Watcher
+	closeOnce sync.Once
 }
 
-// NewSyncer creates a syncer.
-func NewSyncer() *Syncer {
+// NewSyncer creates a syncer that uploads to the given agent.
+func NewSyncer(agent Agent) *Syncer {
 	return &Syncer{
+		Agent:    agent,
 		Event:    make(chan *Event),
 		Error:    make(chan error),
 		Watchers: make([]*Watcher, 0),
@@ -432,8 +613,8 @@ func (syncer *Syncer) GetWatcher(container *schemas.Container) (*Watcher, bool)
 }
 
 // Watch starts watching the given container syncing changes.
-func (syncer *Syncer) Watch(container *schemas.Container) {
-	watcher := NewWatcher(container)
+func (syncer *Syncer) Watch(container *schemas.Container, conf *BoweryConf) *Watcher {
+	watcher := NewWatcher(container, conf, syncer.Agent)
 	syncer.Watchers = append(syncer.Watchers, watcher)
 
 	// Do the actual event management, and the inital upload.
@@ -441,13 +622,21 @@ func (syncer *Syncer) Watch(container *schemas.Container) {
 		syncer.Event <- &Event{Container: watcher.Container, Status: delancey.UploadStartStatus}
 		err := watcher.Upload()
 		if err != nil {
+			watcher.mutex.Lock()
+			watcher.failed = true
+			watcher.mutex.Unlock()
+			close(watcher.uploaded)
+
 			syncer.Error <- err
 			return
 		}
+		close(watcher.uploaded)
 		syncer.Event <- &Event{Container: watcher.Container, Status: delancey.UploadFinishStatus}
 
 		watcher.Start(syncer.Event, syncer.Error)
 	}()
+
+	return watcher
 }
 
 // Remove removes a containers syncer.
@@ -466,18 +655,26 @@ func (syncer *Syncer) Remove(container *schemas.Container) error {
 	return nil
 }
 
-// Close closes all the watchers.
+// Close flushes the pending changes for all the watchers and closes them,
+// calling it again does nothing.
 func (syncer *Syncer) Close() error {
-	for _, watcher := range syncer.Watchers {
-		if watcher == nil {
-			continue
-		}
+	syncer.closeOnce.Do(func() {
+		var wg sync.WaitGroup
 
-		err := watcher.Close()
-		if err != nil {
-			return err
+		for _, watcher := range syncer.Watchers {
+			if watcher == nil {
+				continue
+			}
+
+			wg.Add(1)
+			go func(watcher *Watcher) {
+				defer wg.Done()
+				watcher.Flush()
+			}(watcher)
 		}
-	}
+
+		wg.Wait()
+	})
 
 	return nil
 }
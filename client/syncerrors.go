@@ -0,0 +1,97 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Kinds of sync errors.
+const (
+	errPermission    = "permission"
+	errNotFound      = "not_found"
+	errNetwork       = "network"
+	errAgentRejected = "agent_rejected"
+	errLocal         = "local"
+)
+
+// maxSyncErrors is the number of recent errors kept for each container.
+const maxSyncErrors = 50
+
+// classifyError finds the kind of a sync error, errors from the agent that
+// aren't caused by the network or local files are rejections.
+func classifyError(err error, fromAgent bool) string {
+	switch {
+	case os.IsPermission(err):
+		return errPermission
+	case os.IsNotExist(err):
+		return errNotFound
+	case isNetworkError(err):
+		return errNetwork
+	case fromAgent:
+		return errAgentRejected
+	}
+
+	return errLocal
+}
+
+// isNetworkError checks if an error came from connecting to or talking
+// with a remote address.
+func isNetworkError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	msg := err.Error()
+	return isNotConnected(err) ||
+		strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "broken pipe")
+}
+
+// SyncErrors keeps the most recent sync errors for each container.
+type SyncErrors struct {
+	errors map[string][]*WatchError
+	mutex  sync.Mutex
+}
+
+// NewSyncErrors creates an empty SyncErrors.
+func NewSyncErrors() *SyncErrors {
+	return &SyncErrors{errors: make(map[string][]*WatchError)}
+}
+
+// Add records an error, dropping the oldest if there are too many.
+func (se *SyncErrors) Add(err *WatchError) {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	list := append(se.errors[err.Container.ID], err)
+	if len(list) > maxSyncErrors {
+		list = list[len(list)-maxSyncErrors:]
+	}
+	se.errors[err.Container.ID] = list
+}
+
+// List returns the errors for a container, newest last.
+func (se *SyncErrors) List(id string) []*WatchError {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	list := make([]*WatchError, len(se.errors[id]))
+	copy(list, se.errors[id])
+	return list
+}
+
+// Clear removes the errors for a container.
+func (se *SyncErrors) Clear(id string) {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	delete(se.errors, id)
+}
@@ -0,0 +1,423 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Bowery/desktop/logger"
+	"github.com/Bowery/gopackages/schemas"
+	"golang.org/x/crypto/ssh"
+)
+
+// terminalScrollback is the number of output bytes replayed on attach.
+const terminalScrollback = 256 * 1024
+
+// terminalReapInterval is how often detached terminals are checked for
+// being idle too long.
+const terminalReapInterval = time.Minute
+
+var terminalIDRe = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+var termLog = logger.New("terminal")
+
+var errTerminalClosed = errors.New("terminal session has exited")
+
+// Terminal is a shell in a container that outlives the WebSocket
+// connections attached to it.
+type Terminal struct {
+	ID          string    `json:"id"`
+	ContainerID string    `json:"containerID"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Attached    bool      `json:"attached"`
+	detachedAt  time.Time
+	session     *ssh.Session
+	stdin       io.WriteCloser
+	scrollback  []byte
+	output      io.Writer
+	conn        io.Closer
+	recorder    *Recorder
+	done        chan struct{}
+	mutex       sync.Mutex
+}
+
+// Attach replays the scrollback to output and sends all new output to it.
+// Any previously attached connection is closed.
+func (term *Terminal) Attach(output io.Writer, conn io.Closer) error {
+	term.mutex.Lock()
+	defer term.mutex.Unlock()
+
+	select {
+	case <-term.done:
+		return errTerminalClosed
+	default:
+	}
+
+	if term.conn != nil {
+		term.conn.Close()
+	}
+	term.detach()
+
+	if len(term.scrollback) > 0 {
+		_, err := output.Write(term.scrollback)
+		if err != nil {
+			return err
+		}
+	}
+
+	term.output = output
+	term.conn = conn
+	term.Attached = true
+	return nil
+}
+
+// Detach stops sending output if output is still the attached writer.
+func (term *Terminal) Detach(output io.Writer) {
+	term.mutex.Lock()
+	defer term.mutex.Unlock()
+
+	if term.output == output {
+		term.detach()
+	}
+}
+
+// detach clears the attached connection, the lock must be held.
+func (term *Terminal) detach() {
+	term.output = nil
+	term.conn = nil
+	term.Attached = false
+	term.detachedAt = time.Now()
+}
+
+// idleFor returns how long the terminal has been detached.
+func (term *Terminal) idleFor() time.Duration {
+	term.mutex.Lock()
+	defer term.mutex.Unlock()
+
+	if term.Attached {
+		return 0
+	}
+	return time.Since(term.detachedAt)
+}
+
+// Write sends input to the shell.
+func (term *Terminal) Write(b []byte) (int, error) {
+	return term.stdin.Write(b)
+}
+
+// Resize changes the size of the shells pty.
+func (term *Terminal) Resize(rows, cols int) error {
+	if term.recorder != nil {
+		term.recorder.Resize(rows, cols)
+	}
+
+	return term.session.WindowChange(rows, cols)
+}
+
+// Signal sends a signal to the shell.
+func (term *Terminal) Signal(sig ssh.Signal) error {
+	return term.session.Signal(sig)
+}
+
+// Done returns a channel that's closed when the shell exits.
+func (term *Terminal) Done() <-chan struct{} {
+	return term.done
+}
+
+// Close ends the shell.
+func (term *Terminal) Close() error {
+	return term.session.Close()
+}
+
+// snapshot returns a copy of the terminals exported fields.
+func (term *Terminal) snapshot() *Terminal {
+	term.mutex.Lock()
+	defer term.mutex.Unlock()
+
+	return &Terminal{
+		ID:          term.ID,
+		ContainerID: term.ContainerID,
+		CreatedAt:   term.CreatedAt,
+		Attached:    term.Attached,
+	}
+}
+
+// pump reads the shells output into the scrollback and the attached
+// writer until the shell exits.
+func (term *Terminal) pump(stdout io.Reader) {
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			if term.recorder != nil {
+				term.recorder.Output(buf[:n])
+			}
+
+			term.mutex.Lock()
+			term.scrollback = append(term.scrollback, buf[:n]...)
+			if len(term.scrollback) > terminalScrollback {
+				term.scrollback = term.scrollback[len(term.scrollback)-terminalScrollback:]
+			}
+
+			if term.output != nil {
+				_, werr := term.output.Write(buf[:n])
+				if werr != nil {
+					term.conn.Close()
+					term.detach()
+				}
+			}
+			term.mutex.Unlock()
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	term.session.Wait()
+	if term.recorder != nil {
+		term.recorder.Close()
+	}
+
+	term.mutex.Lock()
+	close(term.done)
+	term.Attached = false
+	term.mutex.Unlock()
+}
+
+// TerminalManager keeps the terminal sessions for all containers. If
+// RecordDir is set, sessions are recorded to it. Terminals detached for
+// longer than IdleTimeout are closed, zero keeps them forever.
+type TerminalManager struct {
+	RecordDir   string
+	IdleTimeout time.Duration
+	pool        *SSHPool
+	terminals   map[string]*Terminal
+	closed      chan struct{}
+	closeOnce   sync.Once
+	mutex       sync.Mutex
+}
+
+// NewTerminalManager creates a TerminalManager using the connections
+// in the given pool.
+func NewTerminalManager(pool *SSHPool) *TerminalManager {
+	terms := &TerminalManager{
+		pool:      pool,
+		terminals: make(map[string]*Terminal),
+		closed:    make(chan struct{}),
+	}
+	go terms.reap()
+
+	return terms
+}
+
+// Open starts a shell in the container with the env given. If id is empty
+// one is generated, otherwise it must be in the generated format and not
+// belong to a live terminal.
+func (terms *TerminalManager) Open(container *schemas.Container, id string, rows, cols int, env map[string]string) (*Terminal, error) {
+	if id == "" {
+		id = newTerminalID()
+	}
+	if !terminalIDRe.MatchString(id) {
+		return nil, fmt.Errorf("terminal id %q must be 16 hex characters", id)
+	}
+	if _, ok := terms.Get(id); ok {
+		return nil, fmt.Errorf("terminal %s is already open", id)
+	}
+
+	client, err := terms.pool.Get(container)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	// Not all servers accept env vars, so failures only get logged.
+	for key, val := range env {
+		err = session.Setenv(key, val)
+		if err != nil {
+			termLog.Warn("Setting terminal env failed", "container", container.ID, "key", key, "error", err)
+		}
+	}
+
+	err = session.RequestPty("xterm-256color", rows, cols, ssh.TerminalModes{ssh.ECHO: 1})
+	if err == nil {
+		var term *Terminal
+		term, err = terms.start(container, id, session, rows, cols)
+		if err == nil {
+			return term, nil
+		}
+	}
+
+	session.Close()
+	return nil, err
+}
+
+// start starts the shell on a session and registers the terminal.
+func (terms *TerminalManager) start(container *schemas.Container, id string, session *ssh.Session, rows, cols int) (*Terminal, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = session.Shell()
+	if err != nil {
+		return nil, err
+	}
+
+	term := &Terminal{
+		ID:          id,
+		ContainerID: container.ID,
+		CreatedAt:   time.Now(),
+		detachedAt:  time.Now(),
+		session:     session,
+		stdin:       stdin,
+		done:        make(chan struct{}),
+	}
+
+	// Another open with the same id may have won the race.
+	terms.mutex.Lock()
+	_, exists := terms.terminals[id]
+	if !exists {
+		terms.terminals[id] = term
+	}
+	terms.mutex.Unlock()
+	if exists {
+		return nil, fmt.Errorf("terminal %s is already open", id)
+	}
+
+	if terms.RecordDir != "" {
+		term.recorder, err = NewRecorder(terms.RecordDir, term, rows, cols)
+		if err != nil {
+			termLog.Error("Recording failed", "terminal", id, "error", err)
+		}
+	}
+	go term.pump(stdout)
+
+	// Forget the terminal once the shell exits.
+	go func() {
+		<-term.Done()
+
+		terms.mutex.Lock()
+		if terms.terminals[id] == term {
+			delete(terms.terminals, id)
+		}
+		terms.mutex.Unlock()
+	}()
+
+	return term, nil
+}
+
+// Get retrieves a live terminal by id.
+func (terms *TerminalManager) Get(id string) (*Terminal, bool) {
+	terms.mutex.Lock()
+	defer terms.mutex.Unlock()
+
+	term, ok := terms.terminals[id]
+	return term, ok
+}
+
+// List returns the live terminals, limited to a container if id is given.
+func (terms *TerminalManager) List(id string) []*Terminal {
+	terms.mutex.Lock()
+	defer terms.mutex.Unlock()
+
+	list := make([]*Terminal, 0)
+	for _, term := range terms.terminals {
+		if id == "" || term.ContainerID == id {
+			list = append(list, term.snapshot())
+		}
+	}
+
+	return list
+}
+
+// Len returns the number of live terminals.
+func (terms *TerminalManager) Len() int {
+	terms.mutex.Lock()
+	defer terms.mutex.Unlock()
+
+	return len(terms.terminals)
+}
+
+// RemoveAll ends all the terminals for a container.
+func (terms *TerminalManager) RemoveAll(id string) {
+	terms.mutex.Lock()
+	defer terms.mutex.Unlock()
+
+	for termID, term := range terms.terminals {
+		if term.ContainerID == id {
+			term.Close()
+			delete(terms.terminals, termID)
+		}
+	}
+}
+
+// Close ends all terminals.
+func (terms *TerminalManager) Close() error {
+	terms.closeOnce.Do(func() {
+		close(terms.closed)
+	})
+
+	terms.mutex.Lock()
+	defer terms.mutex.Unlock()
+
+	for id, term := range terms.terminals {
+		term.Close()
+		delete(terms.terminals, id)
+	}
+
+	return nil
+}
+
+// reap closes terminals that have been detached longer than the idle
+// timeout until the manager is closed.
+func (terms *TerminalManager) reap() {
+	ticker := time.NewTicker(terminalReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-terms.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if terms.IdleTimeout <= 0 {
+			continue
+		}
+
+		terms.mutex.Lock()
+		for id, term := range terms.terminals {
+			if term.idleFor() > terms.IdleTimeout {
+				termLog.Info("Closing idle terminal", "terminal", id, "container", term.ContainerID)
+				term.Close()
+				delete(terms.terminals, id)
+			}
+		}
+		terms.mutex.Unlock()
+	}
+}
+
+// newTerminalID generates a random terminal id.
+func newTerminalID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,297 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// Prefixes for the messages sent over a terminal WebSocket. Data messages
+// carry terminal input and output, control messages carry a JSON ControlMsg.
+const (
+	dataPrefix    = "data: "
+	controlPrefix = "control: "
+	legacyPrefix  = "event: "
+)
+
+// Types of control messages.
+const (
+	controlResize = "resize"
+	controlSignal = "signal"
+	controlPing   = "ping"
+	controlPong   = "pong"
+	controlEnv    = "env"
+	controlError  = "error"
+)
+
+// Timeouts for terminal WebSockets, sockets that don't respond to a ping
+// within the read timeout are closed.
+const (
+	socketPingInterval = 30 * time.Second
+	socketReadTimeout  = 90 * time.Second
+	socketWriteTimeout = 10 * time.Second
+)
+
+// maxTermSize is the largest number of rows or columns accepted.
+const maxTermSize = 1000
+
+// termSignals are the signals that can be sent to a shell.
+var termSignals = map[string]ssh.Signal{
+	"HUP":  ssh.SIGHUP,
+	"INT":  ssh.SIGINT,
+	"KILL": ssh.SIGKILL,
+	"QUIT": ssh.SIGQUIT,
+	"TERM": ssh.SIGTERM,
+	"USR1": ssh.SIGUSR1,
+	"USR2": ssh.SIGUSR2,
+}
+
+// ControlMsg is a control message sent over a terminal WebSocket.
+type ControlMsg struct {
+	Type   string            `json:"type"`
+	Cols   int               `json:"cols,omitempty"`
+	Rows   int               `json:"rows,omitempty"`
+	Signal string            `json:"signal,omitempty"`
+	Env    map[string]string `json:"env,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// ParseControl parses and validates a control message.
+func ParseControl(data []byte) (*ControlMsg, error) {
+	msg := new(ControlMsg)
+	err := json.Unmarshal(data, msg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control message: %s", err)
+	}
+
+	return msg, msg.Validate()
+}
+
+// parseLegacyEvent parses the "resize cols rows" event format.
+func parseLegacyEvent(event string) (*ControlMsg, error) {
+	fields := strings.Fields(event)
+	if len(fields) != 3 || fields[0] != controlResize {
+		return nil, fmt.Errorf("invalid event %q", event)
+	}
+
+	cols, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid event %q", event)
+	}
+	rows, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid event %q", event)
+	}
+
+	msg := &ControlMsg{Type: controlResize, Cols: cols, Rows: rows}
+	return msg, msg.Validate()
+}
+
+// Validate checks the message has the fields required for its type.
+func (msg *ControlMsg) Validate() error {
+	switch msg.Type {
+	case controlResize:
+		if msg.Cols < 1 || msg.Cols > maxTermSize || msg.Rows < 1 || msg.Rows > maxTermSize {
+			return fmt.Errorf("resize to %dx%d is out of range", msg.Cols, msg.Rows)
+		}
+	case controlSignal:
+		if _, ok := termSignals[msg.Signal]; !ok {
+			return fmt.Errorf("signal %q is not supported", msg.Signal)
+		}
+	case controlEnv:
+		if len(msg.Env) <= 0 {
+			return errors.New("env message has no variables")
+		}
+		for key := range msg.Env {
+			if !envKeyRe.MatchString(key) {
+				return fmt.Errorf("env key %q is not a valid variable name", key)
+			}
+		}
+	case controlPing, controlPong:
+	case "":
+		return errors.New("control message has no type")
+	default:
+		return fmt.Errorf("control message type %q is not supported", msg.Type)
+	}
+
+	return nil
+}
+
+// TermSocket wraps a terminal WebSocket, splitting incoming messages into
+// input and control messages and keeping the connection alive.
+type TermSocket struct {
+	Input   chan []byte
+	Control chan *ControlMsg
+	conn    *websocket.Conn
+	done    chan struct{}
+	isDone  bool
+	mutex   sync.Mutex
+}
+
+// NewTermSocket starts reading from a WebSocket connection.
+func NewTermSocket(conn *websocket.Conn) *TermSocket {
+	socket := &TermSocket{
+		Input:   make(chan []byte),
+		Control: make(chan *ControlMsg),
+		conn:    conn,
+		done:    make(chan struct{}),
+	}
+
+	conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	})
+
+	go socket.read()
+	go socket.ping()
+	return socket
+}
+
+// Write sends terminal output as a data message.
+func (socket *TermSocket) Write(b []byte) (int, error) {
+	msg := make([]byte, 0, len(dataPrefix)+len(b))
+	msg = append(append(msg, dataPrefix...), b...)
+
+	err := socket.write(websocket.BinaryMessage, msg)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// SendControl sends a control message.
+func (socket *TermSocket) SendControl(msg *ControlMsg) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return socket.write(websocket.BinaryMessage, append([]byte(controlPrefix), data...))
+}
+
+// SendError sends an error control message.
+func (socket *TermSocket) SendError(err error) error {
+	return socket.SendControl(&ControlMsg{Type: controlError, Error: err.Error()})
+}
+
+// Done returns a channel that's closed when the socket closes.
+func (socket *TermSocket) Done() <-chan struct{} {
+	return socket.done
+}
+
+// Close closes the connection.
+func (socket *TermSocket) Close() error {
+	socket.mutex.Lock()
+	if !socket.isDone {
+		socket.isDone = true
+		close(socket.done)
+	}
+	socket.mutex.Unlock()
+
+	return socket.conn.Close()
+}
+
+// CloseGoingAway tells the other end the connection is going away and
+// closes it.
+func (socket *TermSocket) CloseGoingAway(reason string) error {
+	socket.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, reason))
+
+	return socket.Close()
+}
+
+// CloseWithError tells the other end the connection failed with err and
+// closes it.
+func (socket *TermSocket) CloseWithError(err error) error {
+	socket.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+
+	return socket.Close()
+}
+
+// write writes a message with the write timeout.
+func (socket *TermSocket) write(typ int, data []byte) error {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+
+	if socket.isDone {
+		return websocket.ErrCloseSent
+	}
+
+	socket.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return socket.conn.WriteMessage(typ, data)
+}
+
+// read reads messages until the connection closes or times out.
+func (socket *TermSocket) read() {
+	defer socket.Close()
+
+	for {
+		_, data, err := socket.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		socket.conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+
+		var msg *ControlMsg
+		switch {
+		case bytes.HasPrefix(data, []byte(dataPrefix)):
+			select {
+			case socket.Input <- data[len(dataPrefix):]:
+			case <-socket.done:
+				return
+			}
+			continue
+		case bytes.HasPrefix(data, []byte(controlPrefix)):
+			msg, err = ParseControl(data[len(controlPrefix):])
+		case bytes.HasPrefix(data, []byte(legacyPrefix)):
+			msg, err = parseLegacyEvent(string(data[len(legacyPrefix):]))
+		default:
+			err = errors.New("unknown message format")
+		}
+		if err != nil {
+			socket.SendError(err)
+			continue
+		}
+
+		// Pings are answered here so they work before a shell is attached.
+		if msg.Type == controlPing {
+			socket.SendControl(&ControlMsg{Type: controlPong})
+			continue
+		}
+		if msg.Type == controlPong {
+			continue
+		}
+
+		select {
+		case socket.Control <- msg:
+		case <-socket.done:
+			return
+		}
+	}
+}
+
+// ping sends WebSocket pings so dead connections hit the read timeout.
+func (socket *TermSocket) ping() {
+	for {
+		select {
+		case <-socket.done:
+			return
+		case <-time.After(socketPingInterval):
+		}
+
+		err := socket.write(websocket.PingMessage, nil)
+		if err != nil {
+			socket.Close()
+			return
+		}
+	}
+}
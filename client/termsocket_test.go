@@ -0,0 +1,257 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestControlMsgValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   ControlMsg
+		valid bool
+	}{
+		{"resize", ControlMsg{Type: controlResize, Cols: 80, Rows: 24}, true},
+		{"resize max", ControlMsg{Type: controlResize, Cols: maxTermSize, Rows: maxTermSize}, true},
+		{"resize zero", ControlMsg{Type: controlResize}, false},
+		{"resize negative", ControlMsg{Type: controlResize, Cols: -1, Rows: 24}, false},
+		{"resize too large", ControlMsg{Type: controlResize, Cols: 80, Rows: maxTermSize + 1}, false},
+		{"signal", ControlMsg{Type: controlSignal, Signal: "INT"}, true},
+		{"signal missing", ControlMsg{Type: controlSignal}, false},
+		{"signal unknown", ControlMsg{Type: controlSignal, Signal: "STOP"}, false},
+		{"signal lowercase", ControlMsg{Type: controlSignal, Signal: "int"}, false},
+		{"env", ControlMsg{Type: controlEnv, Env: map[string]string{"FOO": "bar"}}, true},
+		{"env empty", ControlMsg{Type: controlEnv}, false},
+		{"env invalid key", ControlMsg{Type: controlEnv, Env: map[string]string{"1FOO": "bar"}}, false},
+		{"ping", ControlMsg{Type: controlPing}, true},
+		{"pong", ControlMsg{Type: controlPong}, true},
+		{"no type", ControlMsg{}, false},
+		{"unknown type", ControlMsg{Type: "input"}, false},
+	}
+
+	for _, test := range tests {
+		err := test.msg.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: expected valid, got %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestParseControl(t *testing.T) {
+	tests := []struct {
+		data  string
+		typ   string
+		valid bool
+	}{
+		{`{"type":"resize","cols":80,"rows":24}`, controlResize, true},
+		{`{"type":"resize","cols":80}`, "", false},
+		{`{"type":"resize","cols":"80","rows":24}`, "", false},
+		{`{"type":"signal","signal":"TERM"}`, controlSignal, true},
+		{`{"type":"signal","signal":"NOPE"}`, "", false},
+		{`{"type":"ping"}`, controlPing, true},
+		{`{"type":`, "", false},
+		{`resize 80 24`, "", false},
+	}
+
+	for _, test := range tests {
+		msg, err := ParseControl([]byte(test.data))
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.data)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: expected valid, got %s", test.data, err)
+			continue
+		}
+		if msg.Type != test.typ {
+			t.Errorf("%s: expected type %s, got %s", test.data, test.typ, msg.Type)
+		}
+	}
+}
+
+func TestParseLegacyEvent(t *testing.T) {
+	tests := []struct {
+		event      string
+		cols, rows int
+		valid      bool
+	}{
+		{"resize 80 24", 80, 24, true},
+		{"  resize   120 40 ", 120, 40, true},
+		{"resize 80", 0, 0, false},
+		{"resize 80 24 1", 0, 0, false},
+		{"resize a 24", 0, 0, false},
+		{"resize 80 b", 0, 0, false},
+		{"resize 0 24", 0, 0, false},
+		{"resize 80 5000", 0, 0, false},
+		{"signal INT", 0, 0, false},
+		{"", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		msg, err := parseLegacyEvent(test.event)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.event)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: expected valid, got %s", test.event, err)
+			continue
+		}
+		if msg.Type != controlResize || msg.Cols != test.cols || msg.Rows != test.rows {
+			t.Errorf("%q: expected resize %dx%d, got %+v", test.event, test.cols, test.rows, msg)
+		}
+	}
+}
+
+// testSocket starts a server wrapping connections in a TermSocket and
+// returns the server side socket and the client connection.
+func testSocket(t *testing.T) (*TermSocket, *websocket.Conn, func()) {
+	sockets := make(chan *TermSocket, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(rw, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		sockets <- NewTermSocket(conn)
+	}))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	socket := <-sockets
+
+	return socket, conn, func() {
+		conn.Close()
+		socket.Close()
+		server.Close()
+	}
+}
+
+func TestTermSocketMessages(t *testing.T) {
+	socket, conn, done := testSocket(t)
+	defer done()
+
+	tests := []struct {
+		frame   string
+		input   string
+		control string
+		err     bool
+	}{
+		{frame: "data: ls -la\n", input: "ls -la\n"},
+		{frame: "data: ", input: ""},
+		{frame: `control: {"type":"resize","cols":100,"rows":30}`, control: controlResize},
+		{frame: `control: {"type":"resize","cols":100,"rows":0}`, err: true},
+		{frame: `control: {"type":"signal","signal":"INT"}`, control: controlSignal},
+		{frame: `control: {"type":"signal","signal":"SEGV"}`, err: true},
+		{frame: `control: not json`, err: true},
+		{frame: "event: resize 80 24", control: controlResize},
+		{frame: "event: resize 80", err: true},
+		{frame: "ls -la", err: true},
+	}
+
+	for _, test := range tests {
+		err := conn.WriteMessage(websocket.BinaryMessage, []byte(test.frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.err {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte(controlPrefix)) {
+				t.Fatalf("%q: expected a control message, got %q", test.frame, data)
+			}
+
+			msg := new(ControlMsg)
+			err = json.Unmarshal(data[len(controlPrefix):], msg)
+			if err != nil {
+				t.Fatalf("%q: invalid control message %q: %s", test.frame, data, err)
+			}
+			if msg.Type != controlError || msg.Error == "" {
+				t.Errorf("%q: expected an error message, got %+v", test.frame, msg)
+			}
+			continue
+		}
+
+		select {
+		case input := <-socket.Input:
+			if test.control != "" || string(input) != test.input {
+				t.Errorf("%q: unexpected input %q", test.frame, input)
+			}
+		case msg := <-socket.Control:
+			if msg.Type != test.control {
+				t.Errorf("%q: unexpected control message %+v", test.frame, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q: timed out waiting for message", test.frame)
+		}
+	}
+}
+
+func TestTermSocketPing(t *testing.T) {
+	socket, conn, done := testSocket(t)
+	defer done()
+
+	err := conn.WriteMessage(websocket.BinaryMessage, []byte(`control: {"type":"ping"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `control: {"type":"pong"}` {
+		t.Errorf("Expected a pong, got %q", data)
+	}
+
+	select {
+	case msg := <-socket.Control:
+		t.Errorf("Ping shouldn't be passed on, got %+v", msg)
+	default:
+	}
+}
+
+func TestTermSocketCloseWithError(t *testing.T) {
+	socket, conn, done := testSocket(t)
+	defer done()
+
+	socket.CloseWithError(errors.New("terminal failed"))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	if !ok {
+		t.Fatalf("Expected a close error, got %v", err)
+	}
+	if closeErr.Code != websocket.CloseInternalServerErr || closeErr.Text != "terminal failed" {
+		t.Errorf("Expected close %d with the error, got %d %q", websocket.CloseInternalServerErr, closeErr.Code, closeErr.Text)
+	}
+}
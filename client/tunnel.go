@@ -0,0 +1,255 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Bowery/desktop/logger"
+	"github.com/Bowery/gopackages/schemas"
+)
+
+// Limits for the delay between tunnel reconnects.
+const (
+	tunnelMinDelay = 500 * time.Millisecond
+	tunnelMaxDelay = 30 * time.Second
+)
+
+var tunnelLog = logger.New("tunnel")
+
+// Tunnel forwards a port in a container to an address on the local machine.
+type Tunnel struct {
+	ContainerID string `json:"containerID"`
+	RemotePort  int    `json:"remotePort"`
+	LocalAddr   string `json:"localAddr"`
+	Connected   bool   `json:"connected"`
+	container   *schemas.Container
+	listener    net.Listener
+	done        chan struct{}
+	mutex       sync.Mutex
+}
+
+// setListener sets the active remote listener, returning false if the
+// tunnel was closed.
+func (tunnel *Tunnel) setListener(listener net.Listener) bool {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+
+	select {
+	case <-tunnel.done:
+		if listener != nil {
+			listener.Close()
+		}
+		return false
+	default:
+	}
+
+	tunnel.listener = listener
+	tunnel.Connected = listener != nil
+	return true
+}
+
+// Copy returns a copy of the tunnels exported fields that's safe to
+// encode while the tunnel is running.
+func (tunnel *Tunnel) Copy() *Tunnel {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+
+	return &Tunnel{
+		ContainerID: tunnel.ContainerID,
+		RemotePort:  tunnel.RemotePort,
+		LocalAddr:   tunnel.LocalAddr,
+		Connected:   tunnel.Connected,
+	}
+}
+
+// close stops the tunnel and its remote listener.
+func (tunnel *Tunnel) close() {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+
+	select {
+	case <-tunnel.done:
+		return
+	default:
+	}
+
+	close(tunnel.done)
+	if tunnel.listener != nil {
+		tunnel.listener.Close()
+	}
+	tunnel.Connected = false
+}
+
+// TunnelManager manages the reverse tunnels for all containers.
+type TunnelManager struct {
+	pool    *SSHPool
+	tunnels map[string][]*Tunnel
+	mutex   sync.Mutex
+}
+
+// NewTunnelManager creates a TunnelManager using the connections
+// in the given pool.
+func NewTunnelManager(pool *SSHPool) *TunnelManager {
+	return &TunnelManager{
+		pool:    pool,
+		tunnels: make(map[string][]*Tunnel),
+	}
+}
+
+// Add starts listening on port remote in the container, forwarding
+// connections to the local address.
+func (tm *TunnelManager) Add(container *schemas.Container, remote int, local string) (*Tunnel, error) {
+	if container.Address == "" {
+		return nil, fmt.Errorf("container %s isn't running yet", container.ID)
+	}
+	if remote < 1 || remote > 65535 {
+		return nil, fmt.Errorf("remote port %d is out of range", remote)
+	}
+	_, _, err := net.SplitHostPort(local)
+	if err != nil {
+		return nil, err
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	for _, tunnel := range tm.tunnels[container.ID] {
+		if tunnel.RemotePort == remote {
+			return nil, fmt.Errorf("a tunnel on port %d already exists for container %s", remote, container.ID)
+		}
+	}
+
+	tunnel := &Tunnel{
+		ContainerID: container.ID,
+		RemotePort:  remote,
+		LocalAddr:   local,
+		container:   container,
+		done:        make(chan struct{}),
+	}
+	tm.tunnels[container.ID] = append(tm.tunnels[container.ID], tunnel)
+	go tm.run(tunnel)
+
+	return tunnel, nil
+}
+
+// List returns the tunnels for a container.
+func (tm *TunnelManager) List(id string) []*Tunnel {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tunnels := make([]*Tunnel, 0, len(tm.tunnels[id]))
+	for _, tunnel := range tm.tunnels[id] {
+		tunnels = append(tunnels, tunnel.Copy())
+	}
+
+	return tunnels
+}
+
+// Remove stops the tunnel on the remote port for a container.
+func (tm *TunnelManager) Remove(id string, remote int) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	for i, tunnel := range tm.tunnels[id] {
+		if tunnel.RemotePort == remote {
+			tm.tunnels[id] = append(tm.tunnels[id][:i], tm.tunnels[id][i+1:]...)
+			tunnel.close()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no tunnel on port %d exists for container %s", remote, id)
+}
+
+// RemoveAll stops all the tunnels for a container.
+func (tm *TunnelManager) RemoveAll(id string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	for _, tunnel := range tm.tunnels[id] {
+		tunnel.close()
+	}
+	delete(tm.tunnels, id)
+}
+
+// Close stops all tunnels.
+func (tm *TunnelManager) Close() error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	for id, tunnels := range tm.tunnels {
+		for _, tunnel := range tunnels {
+			tunnel.close()
+		}
+		delete(tm.tunnels, id)
+	}
+
+	return nil
+}
+
+// run keeps the remote listener open, reconnecting when the ssh
+// connection drops until the tunnel is closed.
+func (tm *TunnelManager) run(tunnel *Tunnel) {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.RemotePort))
+	delay := tunnelMinDelay
+
+	for {
+		client, err := tm.pool.Get(tunnel.container)
+		var listener net.Listener
+		if err == nil {
+			listener, err = client.Listen("tcp", addr)
+		}
+
+		if err == nil {
+			if !tunnel.setListener(listener) {
+				return
+			}
+			delay = tunnelMinDelay
+
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					break
+				}
+
+				go tm.handle(tunnel, conn)
+			}
+		} else {
+			tunnelLog.Warn("Tunnel failed, reconnecting", "container", tunnel.ContainerID, "port", tunnel.RemotePort, "delay", delay, "error", err)
+		}
+
+		if !tunnel.setListener(nil) {
+			return
+		}
+
+		// Wait before reconnecting, or stop if closed meanwhile.
+		select {
+		case <-tunnel.done:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > tunnelMaxDelay {
+			delay = tunnelMaxDelay
+		}
+	}
+}
+
+// handle proxies a remote connection to the local address.
+func (tm *TunnelManager) handle(tunnel *Tunnel, conn net.Conn) {
+	defer conn.Close()
+
+	local, err := net.DialTimeout("tcp", tunnel.LocalAddr, 10*time.Second)
+	if err != nil {
+		tunnelLog.Warn("Tunnel connection failed", "container", tunnel.ContainerID, "addr", tunnel.LocalAddr, "error", err)
+		return
+	}
+	defer local.Close()
+
+	pipe(conn, local)
+}
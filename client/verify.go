@@ -0,0 +1,283 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Bowery/delancey/delancey"
+	"github.com/Bowery/gopackages/config"
+	"github.com/Bowery/gopackages/schemas"
+	"github.com/Bowery/ignores"
+)
+
+// States of a verify job.
+const (
+	verifyRunning = "running"
+	verifyDone    = "done"
+	verifyFailed  = "failed"
+)
+
+// VerifyReq is the body of a request to verify a container.
+type VerifyReq struct {
+	RemotePath string `json:"remotePath"`
+	Repair     bool   `json:"repair"`
+}
+
+// DriftReport lists the files that differ between the local path and
+// the container, paths are relative and slash separated.
+type DriftReport struct {
+	Missing   []string `json:"missing"`
+	Extra     []string `json:"extra"`
+	Differing []string `json:"differing"`
+	Repaired  []string `json:"repaired,omitempty"`
+}
+
+// VerifyJob is a running or finished verification of a container.
+type VerifyJob struct {
+	ContainerID string       `json:"containerID"`
+	Status      string       `json:"status"`
+	Report      *DriftReport `json:"report,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	StartedAt   time.Time    `json:"startedAt"`
+	FinishedAt  time.Time    `json:"finishedAt,omitempty"`
+}
+
+// VerifyJobs keeps the latest verify job for each container.
+type VerifyJobs struct {
+	jobs  map[string]*VerifyJob
+	mutex sync.Mutex
+}
+
+// NewVerifyJobs creates a VerifyJobs.
+func NewVerifyJobs() *VerifyJobs {
+	return &VerifyJobs{jobs: make(map[string]*VerifyJob)}
+}
+
+// Start starts verifying a container unless a job is already running.
+func (vj *VerifyJobs) Start(container *schemas.Container, conf *BoweryConf, req *VerifyReq) (*VerifyJob, error) {
+	vj.mutex.Lock()
+	defer vj.mutex.Unlock()
+
+	job, ok := vj.jobs[container.ID]
+	if ok && job.Status == verifyRunning {
+		return nil, fmt.Errorf("container %s is already being verified", container.ID)
+	}
+
+	job = &VerifyJob{ContainerID: container.ID, Status: verifyRunning, StartedAt: time.Now()}
+	vj.jobs[container.ID] = job
+
+	go func() {
+		report, err := verifyContainer(container, conf, req)
+
+		vj.mutex.Lock()
+		defer vj.mutex.Unlock()
+		job.FinishedAt = time.Now()
+		job.Report = report
+		if err != nil {
+			job.Status = verifyFailed
+			job.Error = err.Error()
+			return
+		}
+
+		job.Status = verifyDone
+	}()
+
+	return job.copy(), nil
+}
+
+// Get returns a copy of the latest job for a container.
+func (vj *VerifyJobs) Get(id string) (*VerifyJob, bool) {
+	vj.mutex.Lock()
+	defer vj.mutex.Unlock()
+
+	job, ok := vj.jobs[id]
+	if !ok {
+		return nil, false
+	}
+
+	return job.copy(), true
+}
+
+// copy copies a job, the report is never changed once set.
+func (job *VerifyJob) copy() *VerifyJob {
+	cp := *job
+	return &cp
+}
+
+// verifyContainer hashes the files on both sides and compares them,
+// repairing the drifted files if requested. Extra files are deleted
+// from the container on repair. If repairing fails the report is still
+// returned with what was repaired so far.
+func verifyContainer(container *schemas.Container, conf *BoweryConf, req *VerifyReq) (*DriftReport, error) {
+	local := container.LocalPath
+	ignoreList, err := ignores.Get(filepath.Join(local, config.IgnorePath))
+	if err != nil {
+		return nil, err
+	}
+	ignoreList = append(ignoreList, conf.IgnorePaths(local)...)
+
+	localHashes, err := hashLocal(local, ignoreList)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteHashes, err := hashRemote(container, req.RemotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DriftReport{
+		Missing:   make([]string, 0),
+		Extra:     make([]string, 0),
+		Differing: make([]string, 0),
+	}
+	for rel, hash := range localHashes {
+		remoteHash, ok := remoteHashes[rel]
+		if !ok {
+			report.Missing = append(report.Missing, rel)
+		} else if remoteHash != hash {
+			report.Differing = append(report.Differing, rel)
+		}
+	}
+	for rel := range remoteHashes {
+		if _, ok := localHashes[rel]; !ok && !isIgnored(filepath.Join(local, filepath.FromSlash(rel)), ignoreList) {
+			report.Extra = append(report.Extra, rel)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Strings(report.Differing)
+
+	if !req.Repair {
+		return report, nil
+	}
+
+	watcher, _ := containerManager.Syncer.GetWatcher(container)
+	if watcher == nil {
+		return report, fmt.Errorf("container %s isn't being synced", container.ID)
+	}
+
+	report.Repaired = make([]string, 0)
+	repair := func(paths []string, status string) error {
+		for _, rel := range paths {
+			err := watcher.Update(filepath.FromSlash(rel), status)
+			if err != nil {
+				return err
+			}
+
+			report.Repaired = append(report.Repaired, rel)
+		}
+
+		return nil
+	}
+
+	err = repair(report.Missing, delancey.CreateStatus)
+	if err == nil {
+		err = repair(report.Differing, delancey.UpdateStatus)
+	}
+	if err == nil {
+		err = repair(report.Extra, delancey.DeleteStatus)
+	}
+
+	return report, err
+}
+
+// hashLocal computes the sha1 of each file under root, skipping ignored paths.
+func hashLocal(root string, ignoreList []string) (map[string]string, error) {
+	hashes := make(map[string]string)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || root == path {
+			return err
+		}
+
+		if isIgnored(path, ignoreList) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		hash := sha1.New()
+		_, err = io.Copy(hash, file)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		hashes[filepath.ToSlash(rel)] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	})
+
+	return hashes, err
+}
+
+// hashRemote computes the sha1 of each file under root in the container.
+func hashRemote(container *schemas.Container, root string) (map[string]string, error) {
+	client, err := containerManager.SSH.Get(container)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	out, err := session.Output("cd " + shellQuote(root) + " && find . -type f -exec sha1sum {} +")
+	if err != nil {
+		return nil, fmt.Errorf("hashing remote files failed: %s %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		hashes[strings.TrimPrefix(fields[1], "./")] = fields[0]
+	}
+
+	return hashes, scanner.Err()
+}
+
+// isIgnored checks if a path or one of its parents is in the ignore list.
+func isIgnored(path string, ignoreList []string) bool {
+	for _, ignore := range ignoreList {
+		if path == ignore || strings.HasPrefix(path, ignore+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,313 @@
+// Copyright 2015 Bowery, Inc.
+
+// Package logger is a leveled logger writing logfmt or JSON lines, shared
+// by the client and updater.
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels, entries below the configured level are dropped.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < DebugLevel || level > ErrorLevel {
+		return "unknown"
+	}
+
+	return levelNames[level]
+}
+
+// ParseLevel parses a level name.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+
+	return InfoLevel, fmt.Errorf("unknown log level %q", name)
+}
+
+// Formats entries can be written in.
+const (
+	LogfmtFormat = "logfmt"
+	JSONFormat   = "json"
+)
+
+// recentSize is the number of entries kept for Recent.
+const recentSize = 2000
+
+// Entry is a single log entry.
+type Entry struct {
+	Time      time.Time              `json:"time"`
+	Level     string                 `json:"level"`
+	Subsystem string                 `json:"subsystem,omitempty"`
+	Msg       string                 `json:"msg"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	level     Level
+}
+
+// Options configure the output shared by all loggers.
+type Options struct {
+	Level  Level
+	Format string
+	// Writers are where entries are written, e.g. a RotatingFile and stderr.
+	Writers []io.Writer
+	// NoTime leaves the time out of written entries, for service managers
+	// that add their own.
+	NoTime bool
+}
+
+// output is where all loggers write to.
+type output struct {
+	opts   Options
+	recent []*Entry
+	next   int
+	mutex  sync.Mutex
+}
+
+var std = &output{opts: Options{Level: InfoLevel, Format: LogfmtFormat, Writers: []io.Writer{os.Stderr}}}
+
+// Setup sets the options for all loggers.
+func Setup(opts Options) {
+	if opts.Format == "" {
+		opts.Format = LogfmtFormat
+	}
+
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+	std.opts = opts
+}
+
+// Recent returns up to n of the latest entries at or above the level, and
+// for the subsystem if given, oldest first.
+func Recent(n int, level Level, subsystem string) []*Entry {
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+
+	entries := make([]*Entry, 0)
+	for i := 0; i < len(std.recent); i++ {
+		entry := std.recent[(std.next+i)%len(std.recent)]
+		if entry == nil || entry.level < level || (subsystem != "" && entry.Subsystem != subsystem) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries
+}
+
+// Logger writes entries for a subsystem with a set of fields.
+type Logger struct {
+	subsystem string
+	fields    map[string]interface{}
+}
+
+// New creates a logger for a subsystem.
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// With returns a logger that adds the key value pairs to every entry.
+func (logger *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make(map[string]interface{}, len(logger.fields)+len(keyvals)/2)
+	for key, val := range logger.fields {
+		fields[key] = val
+	}
+	addFields(fields, keyvals)
+
+	return &Logger{subsystem: logger.subsystem, fields: fields}
+}
+
+// Debug writes a debug entry with the key value pairs.
+func (logger *Logger) Debug(msg string, keyvals ...interface{}) {
+	logger.log(DebugLevel, msg, keyvals)
+}
+
+// Info writes an info entry with the key value pairs.
+func (logger *Logger) Info(msg string, keyvals ...interface{}) {
+	logger.log(InfoLevel, msg, keyvals)
+}
+
+// Warn writes a warning entry with the key value pairs.
+func (logger *Logger) Warn(msg string, keyvals ...interface{}) {
+	logger.log(WarnLevel, msg, keyvals)
+}
+
+// Error writes an error entry with the key value pairs.
+func (logger *Logger) Error(msg string, keyvals ...interface{}) {
+	logger.log(ErrorLevel, msg, keyvals)
+}
+
+// Fatal writes an error entry and exits.
+func (logger *Logger) Fatal(msg string, keyvals ...interface{}) {
+	logger.log(ErrorLevel, msg, keyvals)
+	os.Exit(1)
+}
+
+// Writer returns a writer that logs each line written at the level, used
+// to capture the standard logger.
+func (logger *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{logger: logger, level: level}
+}
+
+// log creates an entry and writes it if it's at or above the level.
+func (logger *Logger) log(level Level, msg string, keyvals []interface{}) {
+	entry := &Entry{
+		Time:      time.Now(),
+		Level:     level.String(),
+		Subsystem: logger.subsystem,
+		Msg:       msg,
+		level:     level,
+	}
+	if len(logger.fields) > 0 || len(keyvals) > 0 {
+		entry.Fields = make(map[string]interface{}, len(logger.fields)+len(keyvals)/2)
+		for key, val := range logger.fields {
+			entry.Fields[key] = val
+		}
+		addFields(entry.Fields, keyvals)
+	}
+
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+	if level < std.opts.Level {
+		return
+	}
+
+	if std.recent == nil {
+		std.recent = make([]*Entry, recentSize)
+	}
+	std.recent[std.next] = entry
+	std.next = (std.next + 1) % len(std.recent)
+
+	line := entry.format(std.opts.Format, !std.opts.NoTime)
+	for _, w := range std.opts.Writers {
+		w.Write(line)
+	}
+}
+
+// Line formats the entry as a logfmt line.
+func (entry *Entry) Line() string {
+	return strings.TrimSuffix(string(entry.format(LogfmtFormat, true)), "\n")
+}
+
+// format formats the entry as a line in the format.
+func (entry *Entry) format(format string, withTime bool) []byte {
+	if format == JSONFormat {
+		data := make(map[string]interface{}, len(entry.Fields)+4)
+		for key, val := range entry.Fields {
+			data[key] = val
+		}
+		if withTime {
+			data["time"] = entry.Time.Format(time.RFC3339Nano)
+		}
+		data["level"] = entry.Level
+		data["msg"] = entry.Msg
+		if entry.Subsystem != "" {
+			data["subsystem"] = entry.Subsystem
+		}
+
+		line, err := json.Marshal(data)
+		if err != nil {
+			line, _ = json.Marshal(map[string]string{"level": entry.Level, "msg": entry.Msg, "error": err.Error()})
+		}
+		return append(line, '\n')
+	}
+
+	var buf bytes.Buffer
+	if withTime {
+		buf.WriteString("time=" + entry.Time.Format(time.RFC3339))
+		buf.WriteByte(' ')
+	}
+	buf.WriteString("level=" + entry.Level)
+	if entry.Subsystem != "" {
+		buf.WriteString(" subsystem=" + quote(entry.Subsystem))
+	}
+	buf.WriteString(" msg=" + quote(entry.Msg))
+
+	keys := make([]string, 0, len(entry.Fields))
+	for key := range entry.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buf.WriteString(" " + key + "=" + quote(fmt.Sprint(entry.Fields[key])))
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// addFields adds the key value pairs to fields, a key without a value is
+// given an empty one.
+func addFields(fields map[string]interface{}, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if i+1 < len(keyvals) {
+			fields[key] = fieldValue(keyvals[i+1])
+		} else {
+			fields[key] = ""
+		}
+	}
+}
+
+// fieldValue converts errors and durations to strings so they encode
+// readably.
+func fieldValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+
+	return val
+}
+
+// quote quotes a logfmt value if it contains spaces, quotes or equals.
+func quote(val string) string {
+	if val == "" || strings.ContainsAny(val, " \t\n\"=") {
+		return strconv.Quote(val)
+	}
+
+	return val
+}
+
+// lineWriter logs each line written to it.
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (lw *lineWriter) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		lw.logger.log(lw.level, line, nil)
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Bowery, Inc.
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile is a log file that's rotated once it reaches MaxSize bytes,
+// keeping MaxBackups old files named path.1 (newest) to path.N.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+	file       *os.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+// OpenRotatingFile opens the log file at path for appending, creating its
+// directory if needed.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = rf.open()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// Write writes to the file, rotating it first if the write would go over
+// the max size. If rotating fails the current file is reopened, and if
+// that fails the write goes to stderr.
+func (rf *RotatingFile) Write(b []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file != nil && rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(b)) > rf.MaxSize {
+		rf.rotate()
+	}
+	if rf.file == nil {
+		err := rf.open()
+		if err != nil {
+			return os.Stderr.Write(b)
+		}
+	}
+
+	n, err := rf.file.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+
+	return rf.file.Close()
+}
+
+// open opens the file and gets its current size.
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups, moves the current file to path.1 and opens
+// a new file. The file is nil if the new one couldn't be opened.
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	rf.file = nil
+	if err != nil {
+		return err
+	}
+
+	if rf.MaxBackups <= 0 {
+		os.Remove(rf.Path)
+	} else {
+		os.Remove(rf.backup(rf.MaxBackups))
+		for i := rf.MaxBackups - 1; i >= 1; i-- {
+			os.Rename(rf.backup(i), rf.backup(i+1))
+		}
+
+		err = os.Rename(rf.Path, rf.backup(1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return rf.open()
+}
+
+// backup returns the path of the nth backup.
+func (rf *RotatingFile) backup(n int) string {
+	return rf.Path + "." + strconv.Itoa(n)
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Bowery, Inc.
+package reporter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File appends errors to a local file as JSON lines, for users that don't
+// want anything sent but still want a record.
+type File struct {
+	Path    string
+	Env     string
+	Release string
+	mutex   sync.Mutex
+}
+
+// fileEntry is a single line in the file.
+type fileEntry struct {
+	Time    time.Time         `json:"time"`
+	Env     string            `json:"env,omitempty"`
+	Release string            `json:"release,omitempty"`
+	Error   string            `json:"error"`
+	Custom  map[string]string `json:"custom,omitempty"`
+}
+
+// NewFile creates a File reporter writing to path.
+func NewFile(path, env, release string) *File {
+	return &File{Path: path, Env: env, Release: release}
+}
+
+// Report appends the error to the file.
+func (f *File) Report(err error, custom map[string]string) error {
+	entry := &fileEntry{
+		Time:    time.Now(),
+		Env:     f.Env,
+		Release: f.Release,
+		Error:   err.Error(),
+		Custom:  custom,
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(f.Path), 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Bowery, Inc.
+
+// Package reporter reports errors to an error tracker, shared by the client
+// and updater. Errors are scrubbed of paths and credentials before they're
+// reported.
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Bowery/gopackages/sys"
+)
+
+// ErrorReporter reports errors along with some custom context.
+type ErrorReporter interface {
+	Report(err error, custom map[string]string) error
+}
+
+// Backends errors can be reported to.
+const (
+	RollbarBackend = "rollbar"
+	SentryBackend  = "sentry"
+	FileBackend    = "file"
+	NoneBackend    = "none"
+)
+
+// EnvVar overrides the configured backend, set it to none to opt out.
+const EnvVar = "BOWERY_ERROR_REPORTING"
+
+// Config selects the backend and its options.
+type Config struct {
+	Backend string `json:"backend"`
+	// DSN is the Sentry DSN, e.g. https://key@sentry.example.com/1.
+	DSN string `json:"dsn,omitempty"`
+	// Path is the file errors are written to for the file backend.
+	Path string `json:"path,omitempty"`
+	Env  string `json:"-"`
+	// Release is the version reported with errors.
+	Release string `json:"-"`
+}
+
+// ConfigPath is the settings file shared by the client and updater.
+func ConfigPath() string {
+	return filepath.Join(os.Getenv(sys.HomeVar), ".bowery", "error_reporting.json")
+}
+
+// ReadConfig reads the config at path, defaulting to Rollbar if it
+// doesn't exist. The environment variable takes precedence over the file.
+func ReadConfig(path string) (*Config, error) {
+	conf := &Config{Backend: RollbarBackend}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(contents, conf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if backend := os.Getenv(EnvVar); backend != "" {
+		conf.Backend = backend
+	}
+	return conf, nil
+}
+
+// New creates the reporter for the config, wrapped so errors are scrubbed.
+// The Rollbar backend uses the given token.
+func New(conf *Config, rollbarToken string) (ErrorReporter, error) {
+	var rep ErrorReporter
+
+	switch conf.Backend {
+	case NoneBackend:
+		return Nop{}, nil
+	case "", RollbarBackend:
+		rep = NewRollbar(rollbarToken, conf.Env)
+	case SentryBackend:
+		sentry, err := NewSentry(conf.DSN, conf.Env, conf.Release)
+		if err != nil {
+			return nil, err
+		}
+		rep = sentry
+	case FileBackend:
+		path := conf.Path
+		if path == "" {
+			path = filepath.Join(os.Getenv(sys.HomeVar), ".bowery", "logs", "errors.log")
+		}
+		rep = NewFile(path, conf.Env, conf.Release)
+	default:
+		return nil, fmt.Errorf("unknown error reporting backend %q", conf.Backend)
+	}
+
+	return NewScrubber(rep), nil
+}
+
+// Nop drops every error, used when opted out.
+type Nop struct{}
+
+// Report does nothing.
+func (Nop) Report(err error, custom map[string]string) error {
+	return nil
+}
@@ -0,0 +1,21 @@
+// Copyright 2015 Bowery, Inc.
+package reporter
+
+import (
+	"github.com/Bowery/gopackages/rollbar"
+)
+
+// Rollbar reports errors to Rollbar.
+type Rollbar struct {
+	client *rollbar.Client
+}
+
+// NewRollbar creates a Rollbar reporter for the token and environment.
+func NewRollbar(token, env string) *Rollbar {
+	return &Rollbar{client: rollbar.NewClient(token, env)}
+}
+
+// Report sends the error to Rollbar.
+func (r *Rollbar) Report(err error, custom map[string]string) error {
+	return r.client.Report(err, custom)
+}
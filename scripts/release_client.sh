@@ -80,6 +80,14 @@ goxc \
 -pv="${version}" \
 xc &> "${root}/debug.log"
 
+echo "--> Cross compiling bowery cli..."
+goxc \
+-wd="${root}/bowery" \
+-d="${root}/build" \
+-bc="linux windows darwin,amd64" \
+-pv="${version}" \
+xc &> "${root}/debug.log"
+
 echo "--> Building Atom Shell App"
 cd "${root}/shell"
 npm install
@@ -26,12 +26,14 @@ peopleBtn.onclick = function (e) {
   peopleEl.className = ''
 }
 
-var baseURL = 'http://localhost:32055'
+var baseURL = 'http://127.0.0.1:32055'
+var token = require('./token')
 var projectID = qmark('project_id')
 var request = require('request')
 request({
   url: baseURL + '/projects/' + projectID,
-  method: 'GET'
+  method: 'GET',
+  headers: token.headers()
 }, function (err, res, body) {
   if (err)
     return
@@ -107,6 +109,7 @@ request({
     request({
       url: baseURL + '/projects/' + projectID,
       method: 'PUT',
+      headers: token.headers(),
       body: JSON.stringify(data.project)
     }, function (err, res, body) {
       if (body.error)
@@ -14,6 +14,7 @@ var request = require('request')
 var rollbar = require('rollbar')
 var open = require('open')
 var TerminalManager = require('./terminal')
+var token = require('./token')
 var tm = new TerminalManager()
 
 // Atom shell modules.
@@ -30,7 +31,7 @@ var binPath = path.join(__dirname, '..', 'bin')
 var clientPath = path.join(binPath, 'client' + ext)
 var updaterPath = path.join(binPath, 'updater' + ext)
 var proc = null
-var localAddr = "http://localhost:32055"
+var localAddr = "http://127.0.0.1:32055"
 require('crash-reporter').start() // Report crashes to our server.
 
 rollbar.init('a7c4e78074034f04b1882af596657295')
@@ -108,29 +109,52 @@ for (var i = 0, e; e = exitEvents[i]; i++) {
   })
 }
 
-// Attempt to get user information and register with mixpanel.
-var email
-var name
-exec('git config user.email', function (err, stdout) {
-  if (err) return
+// gitConfig gets a value from the users git config, or an empty string.
+function gitConfig (key, cb) {
+  exec('git config ' + key, function (err, stdout) {
+    cb(err ? '' : stdout.replace(/(\r\n|\n|\r)/gm, ''))
+  })
+}
+
+// identifyUser registers the user with mixpanel using only the fields the
+// privacy settings allow. Nothing is sent if the email isn't allowed since
+// it's the id mixpanel knows the user by, or if the settings can't be read.
+function identifyUser (attempts) {
+  request({
+    url: localAddr + '/settings/privacy',
+    method: 'GET',
+    headers: token.headers(),
+    json: true
+  }, function (err, res, body) {
+    // The client may still be starting.
+    if (err || res.statusCode != 200) {
+      if (attempts > 1) setTimeout(identifyUser.bind(null, attempts - 1), 1000)
+      return
+    }
 
-  email = stdout.replace(/(\r\n|\n|\r)/gm, '')
-  exec('git config user.name', function (err, stdout) {
-    if (err) return
+    var settings = body.settings
+    if (!settings || !settings.sendEmail) return
 
-    name = stdout.replace(/(\r\n|\n|\r)/gm, '')
-    mixpanel.people.set(email, {
-      $email: email,
-      name: name
-    })
+    gitConfig('user.email', function (gitEmail) {
+      var email = settings.email || gitEmail
+      if (!email) return
 
-    mixpanel.track('opened app', {
-      distinct_id: email
-    })
+      gitConfig('user.name', function (gitName) {
+        var props = {$email: email}
+        if (settings.sendName) props.name = settings.name || gitName
 
-    tm.setMixpanel(mixpanel, email)
+        mixpanel.people.set(email, props)
+        mixpanel.track('opened app', {
+          distinct_id: email
+        })
+
+        tm.setMixpanel(mixpanel, email)
+      })
+    })
   })
-})
+}
+
+identifyUser(10)
 
 app.on('window-all-closed', function() {
   console.log('$$$ window-all-closed')
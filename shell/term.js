@@ -1,5 +1,6 @@
 lib.rtdep('lib.f', 'lib.Storage', 'hterm')
 var ipc = require('ipc')
+var token = require('./token')
 
 ipc.on('canceled', function (){
   if (window.instance.exited) {
@@ -29,15 +30,31 @@ window.onload = function () {
   window.term = terminal
 }
 
+// Leave the shell running so it can be reattached after a reload.
 window.onbeforeunload = function () {
   var conn = window.instance.conn
 
   if (conn) {
     conn.onclose = null
-    conn.send('data: exit\r')
+    conn.close()
   }
 }
 
+// terminalSession gets the id of the terminal session for this window,
+// creating one if needed.
+function terminalSession () {
+  var id = window.sessionStorage.getItem('terminal-session')
+  if (!id) {
+    id = ''
+    for (var i = 0; i < 16; i++) {
+      id += Math.floor(Math.random() * 16).toString(16)
+    }
+    window.sessionStorage.setItem('terminal-session', id)
+  }
+
+  return id
+}
+
 // Preferences for hterm.
 hterm.PreferenceManager = function (id) {
   hterm.defaultStorage = new lib.Storage.Local
@@ -114,8 +131,9 @@ hterm.PreferenceManager = function (id) {
 
     // Create websocket connection.
     var query = 'cols=' + this.cols + '&rows=' + this.rows
-      + '&ip=' + qmark('ip') + '&user=' + qmark('user') + '&password=' + qmark('password')
-    this.conn = new WebSocket('ws://localhost:32055/_/ssh'+'?'+query)
+      + '&id=' + qmark('id') + '&ip=' + qmark('ip')
+      + '&session=' + terminalSession() + '&token=' + token.get()
+    this.conn = new WebSocket('ws://127.0.0.1:32055/_/ssh'+'?'+query)
     this.conn.binaryType = 'arraybuffer'
 
     this.conn.onerror = function (err) {
@@ -133,8 +151,15 @@ hterm.PreferenceManager = function (id) {
     this.conn.onmessage = function (ev) {
       var dataView = new DataView(ev.data)
       var decoder = new TextDecoder('utf-8')
+      var msg = decoder.decode(dataView)
+
+      if (msg.indexOf('control: ') == 0) {
+        var control = JSON.parse(msg.slice('control: '.length))
+        if (control.type == 'error') console.log('terminal error:', control.error)
+        return
+      }
 
-      self.io.writeUTF8(decoder.decode(dataView).slice('data: '.length))
+      self.io.writeUTF8(msg.slice('data: '.length))
     }
 
     // Handle io events.
@@ -152,7 +177,11 @@ hterm.PreferenceManager = function (id) {
       self.cols = cols
       self.rows = rows
 
-      self.conn.send('event: resize ' + cols + ' ' + rows)
+      self.conn.send('control: ' + JSON.stringify({
+        type: 'resize',
+        cols: cols,
+        rows: rows
+      }))
     }
   }
 
@@ -179,6 +208,7 @@ hterm.PreferenceManager = function (id) {
       return
     }
     this.exited = true
+    window.sessionStorage.removeItem('terminal-session')
 
     this.io.pop()
     if (this.argv.onExit) {
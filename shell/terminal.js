@@ -13,7 +13,8 @@ var Q = require('kew')
 var request = require('request')
 var Pusher = require('pusher-client')
 var pusherC = new Pusher('bbdd9d611b463822cf6e')
-var baseURL = 'http://localhost:32055'
+var baseURL = 'http://127.0.0.1:32055'
+var token = require('./token')
 
 /**
  * TerminalManager maintains the state for all
@@ -235,7 +236,8 @@ Terminal.prototype._req = function (path, method, body) {
   var defer = Q.defer()
   var opts = {
     url: baseURL + path,
-    method: method
+    method: method,
+    headers: token.headers()
   }
   if (body) opts.body = JSON.stringify(body)
 
@@ -325,7 +327,8 @@ Terminal.prototype.saveAndDelete = function() {
   var self = this
   request({
     url: baseURL + '/containers/' + this.container._id,
-    method: 'PUT'
+    method: 'PUT',
+    headers: token.headers()
   }, function (err, res, body) {
     if (body.error) {
       self._handleInsufficientPermissions()
@@ -336,7 +339,8 @@ Terminal.prototype.saveAndDelete = function() {
     self._subChan.on('saved', function (data) {
       request({
         url: baseURL + '/containers/' + self.container._id,
-        method: 'DELETE'
+        method: 'DELETE',
+        headers: token.headers()
       }, function (err, res, body) {
         self.getDelegate().remove(self)
         if (self._infoWindow)
@@ -356,7 +360,8 @@ Terminal.prototype.export = function () {
   var self = this
   request({
     url: baseURL + '/env/' + this.container.address,
-    method: 'GET'
+    method: 'GET',
+    headers: token.headers()
   }, function (err, res, body) {
     if (err)
       return
@@ -405,7 +410,8 @@ Terminal.prototype.saveAndExport = function() {
   var self = this
   request({
     url: baseURL + '/containers/' + this.container._id,
-    method: 'PUT'
+    method: 'PUT',
+    headers: token.headers()
   }, function (err, res, body) {
 
     if (body.error) {
@@ -680,13 +686,10 @@ Terminal.prototype._handleWindowClose = function (e) {
  */
 Terminal.prototype.connect = function () {
   var ip = this.container.address
-  var user = this.container.user
-  var password = this.container.password
   var query = require('url').format({
     query: {
-      ip: ip,
-      user: user,
-      password: password
+      id: this.container._id,
+      ip: ip
     }
   })
 
@@ -0,0 +1,37 @@
+// Copyright 2015 Bowery, Inc.
+/**
+ * @fileoverview Reads the token the client requires on every request.
+ * The client writes a new token to a file only the user can read each
+ * time it starts.
+ */
+
+var fs = require('fs')
+var path = require('path')
+var homeVar = /^win/.test(process.platform) ? 'USERPROFILE' : 'HOME'
+var tokenPath = path.join(process.env[homeVar], '.bowery_token')
+
+/**
+ * get returns the current token, or an empty string if the client
+ * hasn't started yet.
+ * @return {string}
+ */
+function get () {
+  try {
+    return fs.readFileSync(tokenPath, 'utf8').trim()
+  } catch (e) {
+    return ''
+  }
+}
+
+/**
+ * headers returns the headers that authorize a request.
+ * @return {Object}
+ */
+function headers () {
+  return {Authorization: 'Bearer ' + get()}
+}
+
+module.exports = {
+  get: get,
+  headers: headers
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 Bowery, Inc.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Bowery/gopackages/sys"
+)
+
+// checkError is the result recorded for checks that failed.
+const checkError = "error"
+
+// checkResults counts the update checks by result, the counts are saved to
+// a file the client reads for its metrics.
+type checkResults struct {
+	Results   map[string]int `json:"results"`
+	LastCheck time.Time      `json:"lastCheck"`
+	mutex     sync.Mutex
+}
+
+// checksPath is the file the check results are saved to.
+func checksPath() string {
+	return filepath.Join(os.Getenv(sys.HomeVar), ".bowery", "updater_checks.json")
+}
+
+// Record counts a check and saves the results.
+func (cr *checkResults) Record(result string) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	cr.Results[result]++
+	cr.LastCheck = time.Now()
+
+	err := cr.save(checksPath())
+	if err != nil {
+		updaterLog.Warn("Saving update checks failed", "error", err)
+	}
+}
+
+// save writes the results to a temporary file and moves it into place so
+// the client never reads a partial file.
+func (cr *checkResults) save(path string) error {
+	data, err := json.Marshal(cr)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(path+".tmp", data, 0600)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
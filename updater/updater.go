@@ -8,7 +8,6 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
@@ -20,8 +19,10 @@ import (
 	"time"
 
 	"bitbucket.org/kardianos/osext"
+	"github.com/Bowery/desktop/logger"
+	"github.com/Bowery/desktop/reporter"
 	"github.com/Bowery/gopackages/config"
-	"github.com/Bowery/gopackages/rollbar"
+	"github.com/Bowery/gopackages/requests"
 	"github.com/Bowery/gopackages/sys"
 	"github.com/Bowery/gopackages/update"
 	"github.com/jeffchao/backoff"
@@ -30,10 +31,12 @@ import (
 const usage = `usage: updater [-d installDir] <update url> <current version> <command> [arguments]`
 
 var (
-	rollbarC      = rollbar.NewClient(config.RollbarToken, "production")
+	updaterLog    = logger.New("updater")
 	pidSetter     = new(syncSetter)
 	updatedSetter = new(syncSetter)
+	checks        = &checkResults{Results: make(map[string]int)}
 	restartSetter = &syncSetter{val: 1}
+	errorReporter reporter.ErrorReporter
 	updateURL     string
 	version       string
 	installDir    string
@@ -58,6 +61,7 @@ func main() {
 		fmt.Fprintln(os.Stderr, usage)
 		os.Exit(2)
 	}
+	setupLogging()
 	updateURL = args[0]
 	version = args[1]
 	cmdArgs := args[2:]
@@ -68,23 +72,25 @@ func main() {
 		out, _ := cmd.CombinedOutput()
 		version = strings.Trim(string(out), " \r\n")
 	}
+
+	// Errors are reported with the version so wait until it's known.
+	setupErrorReporting()
 	if version == "" {
 		err := errors.New("A version couldn't be detected, please provide a version")
-		rollbarC.Report(err, nil)
+		errorReporter.Report(err, nil)
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	log.Println("Current version:", version)
+	updaterLog.Info("Current version", "version", version)
 
 	// Parse install directory.
 	if installDir == "" || !filepath.IsAbs(installDir) {
 		binDir, err := osext.ExecutableFolder()
 		if err != nil {
-			rollbarC.Report(err, map[string]string{
+			errorReporter.Report(err, map[string]string{
 				"version": version,
 			})
-			log.Println(err)
-			os.Exit(1)
+			updaterLog.Fatal("Finding the executable failed", "error", err)
 		}
 
 		installDir = filepath.Join(binDir, installDir)
@@ -109,10 +115,10 @@ func main() {
 			if !exponential.Next() {
 				err := killPid()
 				if err != nil {
-					rollbarC.Report(err, map[string]string{
+					errorReporter.Report(err, map[string]string{
 						"version": version,
 					})
-					log.Println("Killing pid error:", err)
+					updaterLog.Error("Killing pid failed", "error", err)
 				}
 				return
 			}
@@ -126,19 +132,19 @@ func main() {
 
 		err := cmd.Start()
 		if err != nil {
-			rollbarC.Report(err, map[string]string{
+			errorReporter.Report(err, map[string]string{
 				"version": version,
 			})
-			log.Println("Command error:", err)
+			updaterLog.Error("Starting command failed", "error", err)
 			continue
 		} else {
 			pidSetter.Set(cmd.Process.Pid)
-			log.Println("Starting process", cmdArgs, "with pid:", pidSetter.Get())
+			updaterLog.Info("Started process", "command", strings.Join(cmdArgs, " "), "pid", pidSetter.Get())
 		}
 
 		err = storePids()
 		if err != nil {
-			rollbarC.Report(err, map[string]string{
+			errorReporter.Report(err, map[string]string{
 				"version": version,
 			})
 		}
@@ -147,24 +153,24 @@ func main() {
 		oldPid := pidSetter.Get()
 		pidSetter.Set(0)
 		if err != nil {
-			log.Println("Command error:", err)
+			updaterLog.Warn("Command failed", "pid", oldPid, "error", err)
 
 			// If the process was signaled don't wait.
 			if cmd.ProcessState != nil {
 				waitStatus, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
 				if ok && waitStatus.Signaled() && updatedSetter.Get() == 1 {
-					log.Println("Process with pid", oldPid, "was signaled to restart")
+					updaterLog.Info("Process was signaled to restart", "pid", oldPid)
 					wait = false
 					continue
 				}
 			}
 
-			rollbarC.Report(err, map[string]string{
+			errorReporter.Report(err, map[string]string{
 				"version": version,
 			})
-			log.Println("Process with pid", oldPid, "failed to exit properly")
+			updaterLog.Error("Process failed to exit properly", "pid", oldPid)
 		} else {
-			log.Println("Process with pid", oldPid, "has exited")
+			updaterLog.Info("Process has exited", "pid", oldPid)
 		}
 	}
 
@@ -197,7 +203,7 @@ func (ss *syncSetter) Set(val int) {
 func killPid() error {
 	pid := pidSetter.Get()
 	if pid > 0 {
-		log.Println("Killing process tree for pid:", pid)
+		updaterLog.Info("Killing process tree", "pid", pid)
 		proc, err := sys.GetPidTree(pid)
 		if err != nil {
 			return err
@@ -217,44 +223,48 @@ func killPid() error {
 // doUpdate will download any new version and replace binaries from the download.
 func doUpdate() error {
 	updatedSetter.Set(0)
-	log.Println("Update is being checked")
+	updaterLog.Debug("Checking for update")
 
 	newVersion, newVersionURL, err := update.GetLatest(updateURL)
 	if err != nil {
-		rollbarC.Report(err, map[string]string{
+		checks.Record(checkError)
+		errorReporter.Report(err, map[string]string{
 			"version": version,
 		})
-		log.Println("Update error:", err)
+		updaterLog.Error("Update failed", "error", err)
 		return err
 	}
 
 	changed, err := update.OutOfDate(version, newVersion)
 	if err != nil {
-		rollbarC.Report(err, map[string]string{
+		checks.Record(checkError)
+		errorReporter.Report(err, map[string]string{
 			"version":    version,
 			"newVersion": newVersion,
 		})
-		log.Println("Update error:", err)
+		updaterLog.Error("Update failed", "error", err)
 		return err
 	}
 
 	if !changed {
-		log.Println("Version hasn't changed")
+		checks.Record(requests.StatusNoUpdate)
+		updaterLog.Debug("Version hasn't changed")
 		return nil
 	}
+	checks.Record(requests.StatusNewUpdate)
 
-	log.Println("Getting contents for version", newVersion, "at", newVersionURL)
+	updaterLog.Info("Downloading version", "version", newVersion, "url", newVersionURL)
 	contents, err := update.DownloadVersion(newVersionURL)
 	if err != nil {
-		rollbarC.Report(err, map[string]string{
+		errorReporter.Report(err, map[string]string{
 			"version":    version,
 			"newVersion": newVersion,
 		})
-		log.Println("Update error:", err)
+		updaterLog.Error("Update failed", "error", err)
 		return err
 	}
 
-	log.Println("Replacing binaries found in downloaded version")
+	updaterLog.Info("Replacing binaries", "version", newVersion)
 	var replaceErr error
 	for info, body := range contents {
 		path := filepath.Join(installDir, info.Name())
@@ -275,11 +285,11 @@ func doUpdate() error {
 		}
 	}
 	if replaceErr != nil {
-		rollbarC.Report(err, map[string]string{
+		errorReporter.Report(err, map[string]string{
 			"version":    version,
 			"newVersion": newVersion,
 		})
-		log.Println("Update error:", replaceErr)
+		updaterLog.Error("Update failed", "error", replaceErr)
 		return replaceErr
 	}
 
@@ -288,15 +298,47 @@ func doUpdate() error {
 	updatedSetter.Set(1)
 	err = killPid()
 	if err != nil {
-		rollbarC.Report(err, map[string]string{
+		errorReporter.Report(err, map[string]string{
 			"version": version,
 		})
-		log.Println("Update error:", err)
+		updaterLog.Error("Update failed", "error", err)
 	}
 
 	return err
 }
 
+// setupLogging logs to a rotated file in the bowery directory, stderr is
+// only used if the file can't be opened.
+func setupLogging() {
+	path := filepath.Join(os.Getenv(sys.HomeVar), ".bowery", "logs", "updater.log")
+	writers := []io.Writer{os.Stderr}
+
+	file, err := logger.OpenRotatingFile(path, 10*1024*1024, 5)
+	if err == nil {
+		writers = []io.Writer{file}
+	}
+
+	logger.Setup(logger.Options{Level: logger.InfoLevel, Writers: writers})
+	if err != nil {
+		updaterLog.Warn("Opening log file failed", "path", path, "error", err)
+	}
+}
+
+// setupErrorReporting creates the error reporter from the config shared
+// with the client, nothing is reported if it's invalid.
+func setupErrorReporting() {
+	conf, err := reporter.ReadConfig(reporter.ConfigPath())
+	if err == nil {
+		conf.Env = "production"
+		conf.Release = version
+		errorReporter, err = reporter.New(conf, config.RollbarToken)
+	}
+	if err != nil {
+		errorReporter = reporter.Nop{}
+		updaterLog.Warn("Error reporting disabled", "error", err)
+	}
+}
+
 // storePids saves the running pids in $TMPDIR/bowery_pids
 func storePids() error {
 	proc, err := sys.GetPidTree(os.Getpid())